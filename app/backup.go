@@ -0,0 +1,64 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/fatih/color"
+)
+
+// wantsBackup returns true if the database config always asks for a backup, or if --backup was passed.
+func wantsBackup(config DatabaseConfig) bool {
+	return config.Backup.Always || backupFirst
+}
+
+// mustBackup backs up the tables that pick returns true for before a destructive command. Logs a fatal if the
+// backup fails, so that nothing is destroyed without it.
+func mustBackup(config DatabaseConfig, db *sql.DB, command string, pick func(table string) bool) {
+	if config.Driver != "mysql" {
+		log.Fatal("backups only support mysql")
+	}
+
+	tables, err := migrate.ListTables(db)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	backup := make([]string, 0)
+
+	for t := range tables {
+		if pick(tables[t]) {
+			backup = append(backup, tables[t])
+		}
+	}
+
+	if err := os.MkdirAll(config.Backup.Dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	name := fmt.Sprintf("%s_%s_%s.sql", config.Name, command, time.Now().Format("20060102150405"))
+	path := filepath.Join(config.Backup.Dir, name)
+	file, err := os.Create(path)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if err := migrate.BackupTables(db, file, backup); err != nil {
+		// a partial backup must not be restored by mistake
+		file.Close()
+		os.Remove(path)
+		log.Fatal(fmt.Sprintf("was not able to back up, nothing was changed: %s", err.Error()))
+	}
+
+	color.Green(fmt.Sprintf("Backed up %d tables to %s", len(backup), path))
+	color.Green(fmt.Sprintf("Restore them with: migrant restore %s", path))
+}
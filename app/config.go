@@ -1,27 +1,81 @@
 package app
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
 
+// matches ${VAR}, ${VAR:-default} and $$
+var envPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// the config keys whose values use environment variables that are not set, and the names of those variables
+var unsetEnvKeys = make(map[string][]string)
+
 // DatabaseConfig stores information about a target database
 type DatabaseConfig struct {
-	Name         string
-	Driver       string
-	Uri          string
-	User         string
-	Pass         string
-	Port         string
-	Host         string
-	Prms         string
-	Default      bool
-	PortForward  bool
-	TunnelConfig TunnelConfig
+	Name              string
+	Driver            string
+	Uri               string
+	User              string
+	Pass              string
+	Port              string
+	Host              string
+	Prms              string
+	Default           bool
+	Protected         bool
+	Environment       string
+	PortForward       bool
+	MigrationTemplate string
+	Versioning        string
+	LintDisable       []string
+	Online            OnlineConfig
+	Batch             BatchConfig
+	Truncate          TruncateConfig
+	Backup            BackupConfig
+	Seeds             map[string][]string
+	SeedBatchSize     int
+	TrackSeeds        bool
+	TunnelConfig      TunnelConfig
+}
+
+// OnlineConfig specifies how migrations marked as online are applied.
+type OnlineConfig struct {
+	ChunkSize      int64
+	ChunkPause     time.Duration
+	MaxReplicaLag  time.Duration
+	MaxReplicaWait time.Duration
+	KeepOldTable   bool
+	Replicas       []string
+}
+
+// BatchConfig specifies the defaults for data migrations.
+type BatchConfig struct {
+	Size  int64
+	Pause time.Duration
+}
+
+// TruncateConfig specifies which tables truncate and seed clear, as glob patterns.
+type TruncateConfig struct {
+	Include []string
+	Exclude []string
+}
+
+// BackupConfig specifies where backups are written, and whether destructive commands always back up first.
+type BackupConfig struct {
+	Dir    string
+	Always bool
 }
 
 // TunnelConfig specifies parameters for a Tunnel.
@@ -42,54 +96,291 @@ type TunnelConfig struct {
 	InsecureHostKeyChecking bool
 }
 
-// search for the config file and return an error if it doesn't exist
-func MustLoadConfig(name string) {
-	var suffix = filepath.Ext(name)
-	name = strings.TrimSuffix(name, suffix)
-	viper.AddConfigPath(".")             // search the current path
-	viper.AddConfigPath("/etc/migrant/") // search in the etc path
-	viper.SetConfigName(name)
-	err := viper.ReadInConfig()
+// TableFilter returns the filter for the tables that truncate and seed clear.
+func (c DatabaseConfig) TableFilter() migrate.TableFilter {
+	return migrate.TableFilter{Include: c.Truncate.Include, Exclude: c.Truncate.Exclude}
+}
 
-	if err != nil {
+// MustLoadConfig loads the config file for the environment or logs a fatal. The config of a database is checked
+// for mistakes when it is looked up with MustFindDBConfig.
+func MustLoadConfig(name, environment string) {
+	if err := LoadConfig(name, environment); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// FindConfig searches for the named db and returns the config for that db if it exists.
+// LoadConfig finds the config file with FindConfigFile and returns an error if it doesn't exist. Environment
+// variables like ${HAMBURGER_PASS} in the values of the file are replaced after it is read, and every key can be
+// overridden with a MIGRANT_ environment variable, like MIGRANT_DATABASES_HAMBURGERS_URI for
+// databases.hamburgers.uri. Environment variables only override databases that are in the file, and cannot add
+// new ones. If an environment is passed, its block under environments is merged on top of the rest
+// of the config.
+func LoadConfig(name, environment string) error {
+	dir, err := os.Getwd()
+
+	if err != nil {
+		return err
+	}
+
+	path, err := FindConfigFile(dir, name)
+
+	if err != nil {
+		return err
+	}
+
+	viper.SetConfigFile(path)
+
+	contents, err := ioutil.ReadFile(viper.ConfigFileUsed())
+
+	if err != nil {
+		return err
+	}
+
+	file := viper.New()
+	file.SetConfigType(strings.TrimPrefix(filepath.Ext(path), "."))
+
+	if err := file.ReadConfig(bytes.NewReader(contents)); err != nil {
+		return err
+	}
+
+	settings := file.AllSettings()
+
+	if environment != "" {
+		if err := useEnvironment(settings, environment); err != nil {
+			return err
+		}
+	}
+
+	unsetEnvKeys = make(map[string][]string)
+
+	for key := range settings {
+		// environments that are not in use are never read
+		if key != "environments" {
+			settings[key] = interpolateSetting(key, settings[key])
+		}
+	}
+
+	// clear the config before merging, since viper only merges values of the same type
+	viper.SetConfigType("yaml")
+
+	if err := viper.ReadConfig(strings.NewReader("")); err != nil {
+		return err
+	}
+
+	if err := viper.MergeConfigMap(settings); err != nil {
+		return err
+	}
+
+	viper.SetEnvPrefix("migrant")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+
+	return nil
+}
+
+// InterpolateEnv replaces ${VAR} with the value of the environment variable VAR, and ${VAR:-default} with the
+// default if VAR is not set or empty. $$ is a literal $. Variables that are not set and have no default are
+// an error.
+func InterpolateEnv(text string) (string, error) {
+	out, missing := interpolateEnv(text)
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variables are not set: %s", strings.Join(missing, ", "))
+	}
+
+	return out, nil
+}
+
+// replace environment variables in the text, and return the names of the ones that are not set
+func interpolateEnv(text string) (string, []string) {
+	var missing []string
+
+	out := envPattern.ReplaceAllStringFunc(text, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+
+		parts := envPattern.FindStringSubmatch(match)
+		val, ok := os.LookupEnv(parts[1])
+
+		switch {
+		case parts[2] != "" && val == "":
+			return parts[3]
+		case !ok:
+			missing = append(missing, parts[1])
+		}
+
+		return val
+	})
+
+	return out, missing
+}
+
+// replace environment variables in every string in a config value. Values that use variables that are not set
+// are kept as they are and recorded in unsetEnvKeys, so that they are only an error if they are read.
+func interpolateSetting(key string, val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		out, missing := interpolateEnv(v)
+
+		if len(missing) > 0 {
+			unsetEnvKeys[key] = missing
+			return v
+		}
+
+		return out
+	case map[string]interface{}, map[interface{}]interface{}:
+		m := cast.ToStringMap(v)
+
+		for k := range m {
+			m[k] = interpolateSetting(key+"."+strings.ToLower(k), m[k])
+		}
+
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = interpolateSetting(key, v[i])
+		}
+
+		return v
+	}
+
+	return val
+}
+
+// returns an error naming the environment variables that are not set, but are used by the key or any key under it
+func needEnv(prefix string) error {
+	missing := make([]string, 0)
+
+	for _, key := range sortedUnsetEnvKeys() {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			missing = append(missing, fmt.Sprintf("%s (used by %s)", strings.Join(unsetEnvKeys[key], ", "), key))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("environment variables are not set: %s", strings.Join(missing, "; "))
+	}
+
+	return nil
+}
+
+// returns the keys that use environment variables that are not set, sorted
+func sortedUnsetEnvKeys() []string {
+	keys := make([]string, 0, len(unsetEnvKeys))
+
+	for k := range unsetEnvKeys {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// FindConfig searches for the named db and returns the config for that db if it exists. The settings that
+// every database shares and the config of the db are checked first, and a fatal listing every problem is logged
+// if there are any. Other databases in the config are not checked, so a mistake in one of them doesn't stop
+// commands for the others.
 func MustFindDBConfig(name string) DatabaseConfig {
 	if name == "default!" {
-		sm := viper.GetStringMap("databases")
+		defaults := make([]string, 0)
 
-		for k := range sm {
+		for _, k := range sortedKeys(viper.GetStringMap("databases")) {
 			if viper.GetBool("databases." + k + ".default") {
-				name = k
-				break
+				defaults = append(defaults, k)
 			}
 		}
 
-		if name == "default!" {
+		switch len(defaults) {
+		case 0:
 			log.Fatal("default database not found")
+		case 1:
+			name = defaults[0]
+		default:
+			log.Fatal("only one database can be the default, found: " + strings.Join(defaults, ", "))
 		}
 	}
 
 	if viper.Get("databases."+name) == nil {
-		log.Fatal("database not found")
+		log.Fatal(fmt.Sprintf("database %s not found, it must be in the config file", name))
 	}
 
 	prefix := "databases." + name
 
+	if problems := ValidateDatabaseConfig(name); len(problems) > 0 {
+		lines := make([]string, len(problems))
+
+		for p := range problems {
+			lines[p] = "\n  " + problems[p].String()
+		}
+
+		log.Fatal(NewErrBadConfig(strings.Join(lines, "")))
+	}
+
 	c := DatabaseConfig{
-		Name:        name,
-		Driver:      viper.GetString(prefix + ".driver"),
-		Uri:         viper.GetString(prefix + ".uri"),
-		User:        viper.GetString(prefix + ".user"),
-		Pass:        viper.GetString(prefix + ".pass"),
-		Host:        viper.GetString(prefix + ".host"),
-		Port:        viper.GetString(prefix + ".port"),
-		Prms:        viper.GetString(prefix + ".prms"),
-		Default:     viper.GetBool(prefix + ".default"),
-		PortForward: viper.GetBool(prefix + ".port_forward"),
+		Name:              name,
+		Driver:            viper.GetString(prefix + ".driver"),
+		Uri:               viper.GetString(prefix + ".uri"),
+		User:              viper.GetString(prefix + ".user"),
+		Pass:              viper.GetString(prefix + ".pass"),
+		Host:              viper.GetString(prefix + ".host"),
+		Port:              viper.GetString(prefix + ".port"),
+		Prms:              viper.GetString(prefix + ".prms"),
+		Default:           viper.GetBool(prefix + ".default"),
+		Protected:         viper.GetBool(prefix + ".protected"),
+		Environment:       viper.GetString(prefix + ".environment"),
+		PortForward:       viper.GetBool(prefix + ".port_forward"),
+		MigrationTemplate: viper.GetString(prefix + ".migration_template"),
+		Versioning:        viper.GetString(prefix + ".versioning"),
+		LintDisable:       viper.GetStringSlice(prefix + ".lint.disable"),
+		Seeds:             viper.GetStringMapStringSlice(prefix + ".seeds"),
+		SeedBatchSize:     viper.GetInt(prefix + ".seed_batch_size"),
+		TrackSeeds:        viper.GetBool(prefix + ".track_seeds"),
+	}
+
+	if c.Environment == "" {
+		c.Environment = viper.GetString("environment")
+	}
+
+	if !migrate.ValidVersioning(c.Versioning) {
+		log.Fatal("unknown versioning scheme: " + c.Versioning)
+	}
+
+	defaults := migrate.DefaultOnlineOptions()
+	viper.SetDefault(prefix+".online.chunk_size", defaults.ChunkSize)
+	viper.SetDefault(prefix+".online.max_replica_lag", defaults.MaxReplicaLag)
+	viper.SetDefault(prefix+".online.max_replica_wait", defaults.MaxReplicaWait)
+
+	c.Online = OnlineConfig{
+		ChunkSize:      viper.GetInt64(prefix + ".online.chunk_size"),
+		ChunkPause:     viper.GetDuration(prefix + ".online.chunk_pause"),
+		MaxReplicaLag:  viper.GetDuration(prefix + ".online.max_replica_lag"),
+		MaxReplicaWait: viper.GetDuration(prefix + ".online.max_replica_wait"),
+		KeepOldTable:   viper.GetBool(prefix + ".online.keep_old_table"),
+		Replicas:       viper.GetStringSlice(prefix + ".online.replicas"),
+	}
+
+	viper.SetDefault(prefix+".batch.size", migrate.DefaultBatchOptions().Size)
+
+	c.Batch = BatchConfig{
+		Size:  viper.GetInt64(prefix + ".batch.size"),
+		Pause: viper.GetDuration(prefix + ".batch.pause"),
+	}
+
+	c.Truncate = TruncateConfig{
+		Include: viper.GetStringSlice(prefix + ".truncate.include"),
+		Exclude: viper.GetStringSlice(prefix + ".truncate.exclude"),
+	}
+
+	if err := c.TableFilter().Validate(); err != nil {
+		log.Fatal("bad truncate pattern: " + err.Error())
+	}
+
+	viper.SetDefault(prefix+".backup.dir", "./backups")
+
+	c.Backup = BackupConfig{
+		Dir:    viper.GetString(prefix + ".backup.dir"),
+		Always: viper.GetBool(prefix + ".backup.always"),
 	}
 
 	if c.PortForward {
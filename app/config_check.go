@@ -0,0 +1,257 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/spf13/viper"
+)
+
+// the secret drivers that LoadSecrets knows
+var secretDrivers = []string{"aws-secretsmanager", "json"}
+
+// ConfigProblem describes a mistake in the config, found at a key path like databases.hamburgers.driver.
+type ConfigProblem struct {
+	Key     string
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	return p.Key + ": " + p.Message
+}
+
+// ValidateConfig checks the loaded config for mistakes that would otherwise only show up when they are used,
+// and returns every problem it finds.
+func ValidateConfig() []ConfigProblem {
+	problems, sources := validateShared()
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	databases := sortedKeys(viper.GetStringMap("databases"))
+
+	if len(databases) == 0 {
+		problem("databases", "at least one database is required")
+	}
+
+	defaults := make([]string, 0)
+
+	for _, name := range databases {
+		prefix := "databases." + name
+
+		if viper.GetBool(prefix + ".default") {
+			defaults = append(defaults, name)
+		}
+
+		problems = append(problems, validateDatabase(prefix, sources)...)
+	}
+
+	if len(defaults) > 1 {
+		problem("databases", "only one database can be the default, found: %s", strings.Join(defaults, ", "))
+	}
+
+	return problems
+}
+
+// ValidateDatabaseConfig checks the settings that every database shares and the config of the named database,
+// and leaves the other databases alone.
+func ValidateDatabaseConfig(name string) []ConfigProblem {
+	problems, sources := validateShared()
+	return append(problems, validateDatabase("databases."+name, sources)...)
+}
+
+// check the settings outside of the databases block, and return the names of the secret sources
+func validateShared() ([]ConfigProblem, []string) {
+	problems := make([]ConfigProblem, 0)
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, key := range sortedUnsetEnvKeys() {
+		if !strings.HasPrefix(key, "databases.") {
+			problem(key, "environment variables are not set: %s", strings.Join(unsetEnvKeys[key], ", "))
+		}
+	}
+
+	if template := viper.GetString("migration_template"); template != "" && !fileExists(template) {
+		problem("migration_template", "file %s does not exist", template)
+	}
+
+	sources := sortedKeys(viper.GetStringMap("secrets"))
+
+	for _, s := range sources {
+		prefix := "secrets." + s
+		driver := viper.GetString(prefix + ".driver")
+
+		switch {
+		case driver == "":
+			problem(prefix+".driver", "is required")
+		case !containsString(secretDrivers, driver):
+			problem(prefix+".driver", "unknown secret driver %s, use one of: %s", driver, strings.Join(secretDrivers, ", "))
+		}
+
+		if viper.GetString(prefix+".uri") == "" {
+			problem(prefix+".uri", "is required")
+		}
+	}
+
+	return problems, sources
+}
+
+// check the config of a single database
+func validateDatabase(prefix string, sources []string) []ConfigProblem {
+	problems := make([]ConfigProblem, 0)
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Key: prefix + "." + key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// values that may be secrets must point at a secret source that exists
+	secretValue := func(key string) string {
+		val := viper.GetString(prefix + "." + key)
+
+		if IsSecretUri(val) {
+			source := strings.Split(strings.TrimPrefix(val, SECRET_PROTOCOL), "/")[0]
+
+			if !containsString(sources, source) {
+				problem(key, "secret source %s is not in the secrets block", source)
+			}
+		}
+
+		return val
+	}
+
+	for _, key := range sortedUnsetEnvKeys() {
+		if strings.HasPrefix(key, prefix+".") {
+			problems = append(problems, ConfigProblem{
+				Key:     key,
+				Message: "environment variables are not set: " + strings.Join(unsetEnvKeys[key], ", "),
+			})
+		}
+	}
+
+	driver := viper.GetString(prefix + ".driver")
+
+	switch {
+	case driver == "":
+		problem("driver", "is required")
+	case !containsString(sql.Drivers(), driver):
+		problem("driver", "unknown driver %s, use one of: %s", driver, strings.Join(sql.Drivers(), ", "))
+	}
+
+	if secretValue("uri") == "" {
+		for _, key := range []string{"user", "pass", "host", "port"} {
+			if secretValue(key) == "" {
+				problem(key, "is required when there is no uri")
+			}
+		}
+	}
+
+	if versioning := viper.GetString(prefix + ".versioning"); !migrate.ValidVersioning(versioning) {
+		problem("versioning", "unknown versioning scheme %s", versioning)
+	}
+
+	if template := viper.GetString(prefix + ".migration_template"); template != "" && !fileExists(template) {
+		problem("migration_template", "file %s does not exist", template)
+	}
+
+	for _, key := range []string{"online.chunk_pause", "online.max_replica_lag", "online.max_replica_wait", "batch.pause"} {
+		if val, ok := viper.Get(prefix + "." + key).(string); ok {
+			if _, err := time.ParseDuration(val); err != nil {
+				problem(key, "%s is not a duration, like 100ms or 2s", val)
+			}
+		}
+	}
+
+	filter := migrate.TableFilter{
+		Include: viper.GetStringSlice(prefix + ".truncate.include"),
+		Exclude: viper.GetStringSlice(prefix + ".truncate.exclude"),
+	}
+
+	if err := filter.Validate(); err != nil {
+		problem("truncate", "bad pattern: %s", err)
+	}
+
+	seeds := viper.GetStringMapStringSlice(prefix + ".seeds")
+
+	for _, set := range sortedKeys(viper.GetStringMap(prefix + ".seeds")) {
+		for _, path := range seeds[set] {
+			if matches, err := filepath.Glob(path); err != nil || len(matches) == 0 {
+				problem("seeds."+set, "%s does not match any files", path)
+			}
+		}
+	}
+
+	if viper.GetBool(prefix + ".port_forward") {
+		problems = append(problems, validateTunnel(prefix+".ssh", secretValue)...)
+	}
+
+	return problems
+}
+
+// check the ssh block of a database that uses port forwarding
+func validateTunnel(prefix string, secretValue func(key string) string) []ConfigProblem {
+	problems := make([]ConfigProblem, 0)
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Key: prefix + "." + key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if viper.Get(prefix) == nil {
+		return append(problems, ConfigProblem{Key: prefix, Message: "is required when port_forward is on"})
+	}
+
+	if secretValue("ssh.username") == "" {
+		problem("username", "is required")
+	}
+
+	identity := secretValue("ssh.identity")
+
+	switch {
+	case identity != "" && !IsSecretUri(identity) && !fileExists(identity):
+		problem("identity", "file %s does not exist", identity)
+	case identity == "" && secretValue("ssh.password") == "":
+		problem("identity", "an identity or a password is required")
+	}
+
+	for _, end := range []string{"local", "jump", "remote"} {
+		if secretValue("ssh."+end+"_uri") == "" && (secretValue("ssh."+end+"_host") == "" || secretValue("ssh."+end+"_port") == "") {
+			problem(end+"_uri", "is required, or %s_host and %s_port", end, end)
+		}
+	}
+
+	return problems
+}
+
+// returns true if there is a file at the path
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// returns true if the list contains the string
+func containsString(list []string, s string) bool {
+	for l := range list {
+		if list[l] == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the keys of a map in order, so that problems are always reported in the same order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
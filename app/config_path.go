@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// the names of the config file that are searched for when -c is not passed
+var configFileNames = []string{"config.yml", "config.yaml"}
+
+// ConfigSearchPaths returns the directories that are searched for the config file, in order: dir, its parents up
+// to the project root (the first directory holding .git, or the root of the file system), then
+// $XDG_CONFIG_HOME/migrant and /etc/migrant.
+func ConfigSearchPaths(dir string) []string {
+	paths := make([]string, 0)
+
+	for {
+		paths = append(paths, dir)
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+
+		if parent == dir {
+			break
+		}
+
+		dir = parent
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+
+	if configHome != "" {
+		paths = append(paths, filepath.Join(configHome, "migrant"))
+	}
+
+	return append(paths, "/etc/migrant")
+}
+
+// FindConfigFile finds the config file to load. A name with a directory in it, like ./configs/x.yml or an
+// absolute path, is used exactly as it is. A bare file name is searched for in the search paths, starting from
+// dir, and an empty name searches for config.yml or config.yaml.
+func FindConfigFile(dir, name string) (string, error) {
+	if name != "" && filepath.Base(name) != name {
+		if _, err := os.Stat(name); err != nil {
+			return "", fmt.Errorf("config file not found: %s", name)
+		}
+
+		return name, nil
+	}
+
+	names := configFileNames
+
+	if name != "" {
+		names = []string{name}
+	}
+
+	searched := ConfigSearchPaths(dir)
+
+	for s := range searched {
+		for n := range names {
+			path := filepath.Join(searched[s], names[n])
+
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("could not find %s in any of: %s", strings.Join(names, " or "), strings.Join(searched, ", "))
+}
+
+// ConfigDir returns the absolute path of the directory the loaded config file is in.
+func ConfigDir() string {
+	dir, err := filepath.Abs(filepath.Dir(viper.ConfigFileUsed()))
+
+	if err != nil {
+		return filepath.Dir(viper.ConfigFileUsed())
+	}
+
+	return dir
+}
@@ -0,0 +1,232 @@
+package app_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Fantamstick/migrant/app"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	os.Setenv("MIGRANT_TEST_HOST", "db.example.com")
+	os.Setenv("MIGRANT_TEST_EMPTY", "")
+	defer os.Unsetenv("MIGRANT_TEST_HOST")
+	defer os.Unsetenv("MIGRANT_TEST_EMPTY")
+
+	t.Run("it replaces environment variables", func(t *testing.T) {
+		out, err := app.InterpolateEnv("host: ${MIGRANT_TEST_HOST}, port: ${MIGRANT_TEST_PORT:-3306}, user: ${MIGRANT_TEST_EMPTY:-root}, price: $$5 $HOME")
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "host: db.example.com, port: 3306, user: root, price: $5 $HOME", out, "should replace variables and defaults")
+	})
+
+	t.Run("it fails on variables that are not set", func(t *testing.T) {
+		_, err := app.InterpolateEnv("pass: ${MIGRANT_TEST_UNSET}")
+
+		assert.NotNil(t, err, "should return an error")
+		assert.Contains(t, err.Error(), "MIGRANT_TEST_UNSET", "should name the variable")
+	})
+}
+
+func TestMustLoadConfigEnv(t *testing.T) {
+	os.Setenv("MIGRANT_TEST_PASS", "secret")
+	os.Setenv("MIGRANT_DATABASES_HAMBURGERS_VERSIONING", "sequential")
+	defer os.Unsetenv("MIGRANT_TEST_PASS")
+	defer os.Unsetenv("MIGRANT_DATABASES_HAMBURGERS_VERSIONING")
+
+	t.Run("it interpolates and overrides config values from the environment", func(t *testing.T) {
+		app.MustLoadConfig("../fixtures/config/env.yml", "")
+		config := app.MustFindDBConfig("hamburgers")
+
+		assert.Equal(t, "root:secret@tcp(localhost:3306)/hamburgers", config.Uri, "should interpolate values")
+		assert.Equal(t, "sequential", config.Versioning, "should override values")
+	})
+}
+
+func TestLoadConfigInterpolation(t *testing.T) {
+	os.Setenv("MIGRANT_TEST_PASS", `*p #a: 'b"&c`)
+	defer os.Unsetenv("MIGRANT_TEST_PASS")
+
+	t.Run("it pastes values in as they are", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/interpolate.yml", "")
+		config := app.MustFindDBConfig("hamburgers")
+
+		assert.Nil(t, err, "should not fail on variables in comments or in databases that are not used")
+		assert.Equal(t, `root:*p #a: 'b"&c@tcp(localhost:3306)/hamburgers`, config.Uri, "should not parse the value as yaml")
+	})
+
+	t.Run("it reports variables that are not set where they are used", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/interpolate.yml", "")
+		assert.Nil(t, err, "should not return an error")
+
+		found := make([]string, 0)
+		for _, p := range app.ValidateConfig() {
+			found = append(found, p.String())
+		}
+
+		assert.Equal(t, []string{"databases.reports.uri: environment variables are not set: MIGRANT_TEST_REPORTS_URI"}, found, "should only report the used variables")
+	})
+
+	t.Run("it only reads the environment in use", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/interpolate.yml", "prod")
+		assert.Nil(t, err, "should not return an error")
+
+		found := make([]string, 0)
+		for _, p := range app.ValidateConfig() {
+			found = append(found, p.String())
+		}
+
+		assert.Contains(t, found, "databases.hamburgers.uri: environment variables are not set: MIGRANT_TEST_PROD_URI", "should report the environment's variables")
+	})
+}
+
+func TestValidateDatabaseConfig(t *testing.T) {
+	t.Run("it only checks the shared settings and the named database", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/broken.yml", "")
+		assert.Nil(t, err, "should not return an error")
+
+		found := make([]string, 0)
+		for _, p := range app.ValidateDatabaseConfig("other") {
+			found = append(found, p.String())
+		}
+
+		assert.Equal(t, []string{
+			"secrets.vault.driver: unknown secret driver hashicorp, use one of: aws-secretsmanager, json",
+		}, found, "should not check the other databases")
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	t.Run("it finds no problems in a good config", func(t *testing.T) {
+		os.Setenv("MIGRANT_TEST_PASS", "secret")
+		defer os.Unsetenv("MIGRANT_TEST_PASS")
+
+		app.MustLoadConfig("../fixtures/config/env.yml", "")
+		assert.Empty(t, app.ValidateConfig(), "should not find problems")
+	})
+
+	t.Run("it reports every problem with its key", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/broken.yml", "")
+		assert.Nil(t, err, "should not return an error")
+
+		found := make([]string, 0)
+		for _, p := range app.ValidateConfig() {
+			found = append(found, p.String())
+		}
+
+		assert.Contains(t, found, "secrets.vault.driver: unknown secret driver hashicorp, use one of: aws-secretsmanager, json", "should check secret drivers")
+		assert.Contains(t, found, "databases: only one database can be the default, found: broken, other", "should check defaults")
+		assert.Contains(t, found, "databases.broken.user: secret source nowhere is not in the secrets block", "should check secret sources")
+		assert.Contains(t, found, "databases.broken.pass: is required when there is no uri", "should check uri components")
+		assert.Contains(t, found, "databases.broken.versioning: unknown versioning scheme calendar", "should check versioning")
+		assert.Contains(t, found, "databases.broken.batch.pause: soon is not a duration, like 100ms or 2s", "should check durations")
+		assert.Contains(t, found, "databases.broken.seeds.dev: ../fixtures/nothing/*.yaml does not match any files", "should check seed paths")
+		assert.Contains(t, found, "databases.broken.ssh.username: is required", "should check ssh blocks")
+		assert.Contains(t, found, "databases.broken.ssh.identity: file ../fixtures/ssh/missing_key does not exist", "should check identity files")
+		assert.Contains(t, found, "databases.broken.ssh.jump_uri: is required, or jump_host and jump_port", "should check tunnel ends")
+
+		driverFound := false
+		for f := range found {
+			driverFound = driverFound || strings.HasPrefix(found[f], "databases.broken.driver: unknown driver oracle")
+		}
+		assert.True(t, driverFound, "should check drivers")
+	})
+}
+
+func TestFindConfigFile(t *testing.T) {
+	root, _ := ioutil.TempDir("", "migrant")
+	defer os.RemoveAll(root)
+
+	project := filepath.Join(root, "project")
+	nested := filepath.Join(project, "db", "migrations")
+	home := filepath.Join(root, "home")
+	os.MkdirAll(filepath.Join(project, ".git"), 0755)
+	os.MkdirAll(nested, 0755)
+	os.MkdirAll(filepath.Join(home, "migrant"), 0755)
+	ioutil.WriteFile(filepath.Join(project, "config.yaml"), []byte("migrations: ./migrations"), 0644)
+	ioutil.WriteFile(filepath.Join(home, "migrant", "global.yml"), []byte("migrations: ./migrations"), 0644)
+
+	os.Setenv("XDG_CONFIG_HOME", home)
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+
+	t.Run("it walks up to the project root", func(t *testing.T) {
+		path, err := app.FindConfigFile(nested, "")
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, filepath.Join(project, "config.yaml"), path, "should find the config in the project root")
+	})
+
+	t.Run("it stops at the project root and then searches the config home", func(t *testing.T) {
+		assert.Equal(t, []string{
+			nested,
+			filepath.Join(project, "db"),
+			project,
+			filepath.Join(home, "migrant"),
+			"/etc/migrant",
+		}, app.ConfigSearchPaths(nested), "should search in order")
+
+		path, err := app.FindConfigFile(nested, "global.yml")
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, filepath.Join(home, "migrant", "global.yml"), path, "should find named configs in the config home")
+	})
+
+	t.Run("it uses paths exactly", func(t *testing.T) {
+		path, err := app.FindConfigFile(nested, filepath.Join(project, "config.yaml"))
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, filepath.Join(project, "config.yaml"), path, "should use absolute paths")
+
+		_, err = app.FindConfigFile(project, "./db/config.yaml")
+
+		assert.NotNil(t, err, "should not search for paths")
+	})
+}
+
+func TestUseEnvironment(t *testing.T) {
+	t.Run("it uses the config as it is without an environment", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/environments.yml", "")
+		config := app.MustFindDBConfig("hamburgers")
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "root@tcp(localhost:3306)/hamburgers", config.Uri, "should use the shared uri")
+		assert.Equal(t, "", config.Environment, "should not have an environment")
+		assert.Equal(t, []string{"prod", "staging"}, app.Environments(), "should list the environments")
+	})
+
+	t.Run("it merges the environment on top of the config", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/environments.yml", "staging")
+		config := app.MustFindDBConfig("hamburgers")
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "migrant@tcp(staging.example.com:3306)/hamburgers", config.Uri, "should override the uri")
+		assert.Equal(t, "mysql", config.Driver, "should keep shared values")
+		assert.Equal(t, []string{"settings", "users"}, config.Truncate.Exclude, "should replace lists")
+		assert.Equal(t, "staging", config.Environment, "should take the environment name")
+		assert.False(t, config.IsProtected(), "should not protect staging")
+	})
+
+	t.Run("it overrides tunnels and secrets", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/environments.yml", "prod")
+		config := app.MustFindDBConfig("hamburgers")
+
+		assert.Nil(t, err, "should not return an error")
+		assert.True(t, config.PortForward, "should turn on port forwarding")
+		assert.Equal(t, "deploy", config.TunnelConfig.Username, "should add the ssh block")
+		assert.Equal(t, "/etc/migrant/secrets.json", viper.GetString("secrets.local.uri"), "should override secret sources")
+		assert.Equal(t, "json", viper.GetString("secrets.local.driver"), "should keep the secret driver")
+		assert.True(t, config.IsProtected(), "should protect prod")
+	})
+
+	t.Run("it fails on unknown environments", func(t *testing.T) {
+		err := app.LoadConfig("../fixtures/config/environments.yml", "qa")
+
+		assert.NotNil(t, err, "should return an error")
+		assert.Contains(t, err.Error(), "use one of: prod, staging", "should list the environments")
+	})
+}
@@ -25,6 +25,24 @@ func MustConnect(config DatabaseConfig) *sql.DB {
 	return con
 }
 
+// mustConnectReplicas connects to the replicas that are checked for lag during online migrations. Replicas use
+// the same driver as the database.
+func mustConnectReplicas(config DatabaseConfig) []*sql.DB {
+	replicas := make([]*sql.DB, 0)
+
+	for r := range config.Online.Replicas {
+		con, err := sql.Open(config.Driver, NeedSecret(config.Online.Replicas[r]))
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		replicas = append(replicas, con)
+	}
+
+	return replicas
+}
+
 // initialize port forwarding if required
 func initPortforwarding(config DatabaseConfig) {
 	t, err := NewTunnel(config.TunnelConfig)
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Environments returns the names of the environments in the config, sorted.
+func Environments() []string {
+	return sortedKeys(viper.GetStringMap("environments"))
+}
+
+// merge the named block under environments on top of the rest of the settings, so that everything read from the
+// config afterwards sees the environment's values. Maps are merged key by key and everything else, lists
+// included, is replaced. Databases that don't set an environment take the name of the one in use.
+func useEnvironment(settings map[string]interface{}, name string) error {
+	environments := cast.ToStringMap(settings["environments"])
+
+	if _, ok := environments[name]; !ok {
+		known := sortedKeys(environments)
+
+		if len(known) == 0 {
+			return fmt.Errorf("environment %s not found, the config has no environments", name)
+		}
+
+		return fmt.Errorf("environment %s not found, use one of: %s", name, strings.Join(known, ", "))
+	}
+
+	mergeSettings(settings, cast.ToStringMap(environments[name]))
+	settings["environment"] = name
+
+	return nil
+}
+
+// merge src into dst, recursing into maps that are in both
+func mergeSettings(dst, src map[string]interface{}) {
+	for key, val := range src {
+		key = strings.ToLower(key)
+		srcMap, srcIsMap := toStringMap(val)
+		dstMap, dstIsMap := toStringMap(dst[key])
+
+		if srcIsMap && dstIsMap {
+			mergeSettings(dstMap, srcMap)
+			dst[key] = dstMap
+			continue
+		}
+
+		dst[key] = val
+	}
+}
+
+// convert the maps that yaml and viper produce into a map with string keys
+func toStringMap(val interface{}) (map[string]interface{}, bool) {
+	switch val.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		return cast.ToStringMap(val), true
+	}
+
+	return nil, false
+}
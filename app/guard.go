@@ -0,0 +1,84 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Fantamstick/migrant/input"
+	"github.com/fatih/color"
+)
+
+// names of hosts and databases that look like production
+var productionPattern = regexp.MustCompile(`(?i)(^|[^a-z])(prod|production|live)([^a-z]|$)`)
+
+// IsProtected returns true if destructive commands need to be forced for the database, because it is marked as
+// protected or its environment is production.
+func (c DatabaseConfig) IsProtected() bool {
+	env := strings.ToLower(c.Environment)
+	return c.Protected || env == "production" || env == "prod"
+}
+
+// LooksLikeProduction returns true if any of the names, like a host or database name, looks like it belongs to
+// a production database.
+func LooksLikeProduction(names ...string) bool {
+	for n := range names {
+		if productionPattern.MatchString(names[n]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mustAllowDestructive stops a destructive command against a protected database, unless --force-protected was
+// passed and the name of the database is typed in. Databases that are not protected but look like production
+// get a warning.
+func mustAllowDestructive(config DatabaseConfig, db *sql.DB, command string) {
+	if !config.IsProtected() {
+		if names := productionNames(config, db); LooksLikeProduction(names...) {
+			color.Yellow(fmt.Sprintf(
+				"Warning: %s looks like a production database, but is not marked as protected in the config",
+				strings.Join(names, ", "),
+			))
+		}
+
+		return
+	}
+
+	if !forceProtected {
+		color.Red(fmt.Sprintf("%s is a protected database, %s will not run against it.", config.Name, command))
+		color.Red("Pass --force-protected if you really mean to.")
+		os.Exit(1)
+	}
+
+	color.Red(fmt.Sprintf("%s is a protected database!", config.Name))
+
+	if !input.ConfirmByTyping(config.Name) {
+		fmt.Print("No further actions will take place.")
+		os.Exit(1)
+	}
+}
+
+// the names of the database and the server it is on, as far as they are known
+func productionNames(config DatabaseConfig, db *sql.DB) []string {
+	names := []string{config.Name}
+
+	if config.Host != "" && !IsSecretUri(config.Host) {
+		names = append(names, config.Host)
+	}
+
+	var host, database sql.NullString
+
+	if config.Driver == "mysql" && db.QueryRow("SELECT @@hostname, DATABASE()").Scan(&host, &database) == nil {
+		for _, name := range []sql.NullString{host, database} {
+			if name.Valid && name.String != "" {
+				names = append(names, name.String)
+			}
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,28 @@
+package app_test
+
+import (
+	"testing"
+
+	"github.com/Fantamstick/migrant/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsProtected(t *testing.T) {
+	t.Run("it protects databases that are marked or in production", func(t *testing.T) {
+		assert.True(t, app.DatabaseConfig{Protected: true}.IsProtected(), "should protect marked databases")
+		assert.True(t, app.DatabaseConfig{Environment: "Production"}.IsProtected(), "should protect production")
+		assert.False(t, app.DatabaseConfig{Environment: "staging"}.IsProtected(), "should not protect other databases")
+	})
+}
+
+func TestLooksLikeProduction(t *testing.T) {
+	t.Run("it spots production names", func(t *testing.T) {
+		assert.True(t, app.LooksLikeProduction("localhost", "db-prod-1.example.com"), "should spot hosts")
+		assert.True(t, app.LooksLikeProduction("hamburgers_production"), "should spot database names")
+		assert.True(t, app.LooksLikeProduction("live"), "should spot live")
+	})
+
+	t.Run("it ignores words that contain production names", func(t *testing.T) {
+		assert.False(t, app.LooksLikeProduction("products", "delivery", "staging"), "should not warn")
+	})
+}
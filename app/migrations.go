@@ -3,9 +3,11 @@ package app
 import (
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"time"
 
 	"github.com/Fantamstick/migrant/input"
 	"github.com/Fantamstick/migrant/migrate"
@@ -14,6 +16,10 @@ import (
 
 // mustFindMigrationsPath looks for the migration path specified in the config and dies if it is not present.
 func mustFindMigrationsPath(config DatabaseConfig) string {
+	if err := needEnv("migrations"); err != nil {
+		log.Fatal(err)
+	}
+
 	checkPath := path.Join(viper.GetString("migrations"), config.Name)
 
 	info, err := os.Stat(checkPath)
@@ -45,5 +51,111 @@ func mustHaveOrCreatedMigrationTable(db *sql.DB) {
 		}
 
 		migrate.InitMigrationTable(db)
+		return
+	}
+
+	// tables created before other versioning schemes existed can only hold timestamps
+	if info.MigrationNameLength() < migrate.MigrationNameLength {
+		fmt.Printf("Widening the name column of the migration table to %d characters\n", migrate.MigrationNameLength)
+
+		if err := migrate.WidenMigrationTable(db); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// mustLoadMigrationTemplate returns the contents of the migration template for the database. A template set on
+// the database takes precedence over the global one, and the default template is used if neither is set.
+func mustLoadMigrationTemplate(config DatabaseConfig) string {
+	templatePath := config.MigrationTemplate
+
+	if templatePath == "" {
+		if err := needEnv("migration_template"); err != nil {
+			log.Fatal(err)
+		}
+
+		templatePath = viper.GetString("migration_template")
+	}
+
+	if templatePath == "" {
+		return migrate.DefaultMigrationTemplate
+	}
+
+	tmpl, err := ioutil.ReadFile(templatePath)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return string(tmpl)
+}
+
+// mustFindPendingMigrations connects to the database and returns its migrations without altering it. If there
+// is no migration table yet every migration is pending.
+func mustFindPendingMigrations(config DatabaseConfig, migrationsPath string) []migrate.MigrationFile {
+	MustLoadSecrets()
+	db := MustConnect(config)
+	defer db.Close()
+
+	info, err := migrate.Stat(db)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !info.HasMigrationTable() {
+		return migrate.ListMigrationFiles(migrationsPath, config.Versioning)
+	}
+
+	return migrate.CheckVersionedMigrations(db, migrationsPath, config.Versioning)
+}
+
+// applyOptions returns the options for applying online migrations and data migrations to the database. Any
+// replicas in the options are connected and should be closed when the migrations are done.
+func applyOptions(config DatabaseConfig) migrate.ApplyOptions {
+	online := migrate.OnlineOptions{
+		ChunkSize:      config.Online.ChunkSize,
+		ChunkPause:     config.Online.ChunkPause,
+		MaxReplicaLag:  config.Online.MaxReplicaLag,
+		MaxReplicaWait: config.Online.MaxReplicaWait,
+		KeepOldTable:   config.Online.KeepOldTable,
+		Replicas:       mustConnectReplicas(config),
+		Progress: func(copied, total int64) {
+			fmt.Printf("\rcopied %d of %d rows", copied, total)
+
+			if copied >= total {
+				fmt.Println()
+			}
+		},
+		Waiting: func(replica int, lag time.Duration) {
+			status := fmt.Sprintf("%s behind", lag)
+
+			if lag < 0 {
+				status = "replication is not running"
+			}
+
+			fmt.Printf("\rwaiting for replica %d, %-40s", replica+1, status)
+		},
+	}
+
+	batch := migrate.BatchOptions{
+		Size:  config.Batch.Size,
+		Pause: config.Batch.Pause,
+		Progress: func(p migrate.BatchProgress) {
+			fmt.Printf("\r%s: up to key %d of %d, %d rows (%.0f rows/sec)", p.Name, p.LastKey, p.MaxKey, p.Rows, p.RowsPerSec)
+
+			if p.LastKey > p.MaxKey {
+				fmt.Println()
+			}
+		},
+	}
+
+	return migrate.ApplyOptions{Online: online, Batch: batch}
+}
+
+// close any replica connections in the options
+func closeReplicas(options migrate.ApplyOptions) {
+	for r := range options.Online.Replicas {
+		options.Online.Replicas[r].Close()
 	}
 }
@@ -1,9 +1,13 @@
 package app
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/Fantamstick/migrant/input"
 	"github.com/Fantamstick/migrant/migrate"
@@ -27,10 +31,10 @@ var (
 	}
 
 	genCommand = &cobra.Command{
-		Use:   "gen",
+		Use:   "gen [description]",
 		Short: "generate a new migration",
 		Run:   gen,
-		Args:  cobra.MinimumNArgs(1),
+		Args:  cobra.MaximumNArgs(1),
 	}
 
 	upCommand = &cobra.Command{
@@ -40,10 +44,24 @@ var (
 	}
 
 	seedCommand = &cobra.Command{
-		Use:   "seed",
+		Use:   "seed [set or file...]",
 		Short: "seed target database",
 		Run:   seed,
-		Args:  cobra.MaximumNArgs(1),
+		Args:  cobra.ArbitraryArgs,
+	}
+
+	seedExportCommand = &cobra.Command{
+		Use:   "export [file]",
+		Short: "export rows from the target database to a seed file",
+		Run:   seedExport,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	seedValidateCommand = &cobra.Command{
+		Use:   "validate [set or file...]",
+		Short: "check seed files for problems without changing the database",
+		Run:   seedValidate,
+		Args:  cobra.ArbitraryArgs,
 	}
 
 	resetCommand = &cobra.Command{
@@ -52,6 +70,36 @@ var (
 		Run:   reset,
 	}
 
+	lintCommand = &cobra.Command{
+		Use:   "lint",
+		Short: "check pending migrations for dangerous statements",
+		Run:   lint,
+	}
+
+	restoreCommand = &cobra.Command{
+		Use:   "restore [file]",
+		Short: "restore tables from a backup",
+		Run:   restore,
+		Args:  cobra.ExactArgs(1),
+	}
+
+	configCommand = &cobra.Command{
+		Use:   "config",
+		Short: "inspect the config",
+	}
+
+	configCheckCommand = &cobra.Command{
+		Use:   "check",
+		Short: "check the config for mistakes",
+		Run:   configCheck,
+	}
+
+	configPathCommand = &cobra.Command{
+		Use:   "path",
+		Short: "show which config file is loaded",
+		Run:   configPath,
+	}
+
 	truncateCommand = &cobra.Command{
 		Use:   "truncate",
 		Short: "truncate all tables in the database",
@@ -61,19 +109,78 @@ var (
 
 // Parameters
 var (
-	configFileName string
-	targetDatabase string
+	configFileName  string
+	environmentName string
+	targetDatabase  string
+	genAuthor       string
+	genCreateTable  string
+	genAddColumn    string
+	genBump         string
+	lintAll         bool
+	seedUpsert      bool
+	seedRandom      int64
+	seedBatchSize   int
+	seedOnce        bool
+	seedVars        []string
+	exportTables    []string
+	exportWheres    []string
+	validateOffline bool
+	truncateInclude []string
+	truncateExclude []string
+	backupFirst     bool
+	forceProtected  bool
 )
 
 func init() {
-	command.PersistentFlags().StringVarP(&configFileName, "config", "c", "./config.yml", "the name of the config file")
+	command.PersistentFlags().StringVarP(&configFileName, "config", "c", "", "the config file, as a path or a name to search for (default config.yml or config.yaml)")
+	command.PersistentFlags().StringVarP(&environmentName, "env", "e", os.Getenv("MIGRANT_ENV"), "which environment in the config to use")
 	command.PersistentFlags().StringVarP(&targetDatabase, "database", "d", "default!", "which database to target (or use default db)")
 
+	genCommand.Flags().StringVar(&genAuthor, "author", os.Getenv("USER"), "the author made available to migration templates")
+	genCommand.Flags().StringVar(&genCreateTable, "create-table", "", "scaffold a migration that creates the named table")
+	genCommand.Flags().StringVar(&genAddColumn, "add-column", "", "scaffold a migration that adds a column, described as table.column:type")
+	genCommand.Flags().StringVar(&genBump, "bump", "minor", "which part of a semantic version to bump (major, minor or patch)")
+
+	lintCommand.Flags().BoolVar(&lintAll, "all", false, "lint every migration without connecting to the database")
+
+	seedCommand.Flags().BoolVar(&seedUpsert, "upsert", false, "insert or update rows by their keys instead of truncating all tables")
+	seedCommand.Flags().Int64Var(&seedRandom, "random-seed", 0, "seed for the fake data helpers")
+	seedCommand.Flags().BoolVar(&seedOnce, "once", false, "only apply seed files that are new or changed since they were last applied")
+	seedCommand.Flags().StringArrayVar(&seedVars, "var", nil, "set a seed variable as key=value, overriding the seed files")
+	seedCommand.Flags().IntVar(&seedBatchSize, "batch-size", 0, "the most rows to insert with a single statement")
+
+	seedExportCommand.Flags().StringSliceVar(&exportTables, "tables", nil, "the tables to export")
+	seedExportCommand.Flags().StringArrayVar(&exportWheres, "where", nil, "a condition for exported rows, or table:condition for a single table")
+	seedExportCommand.MarkFlagRequired("tables")
+	seedCommand.AddCommand(seedExportCommand)
+
+	seedValidateCommand.Flags().BoolVar(&validateOffline, "offline", false, "only check the seed files, without connecting to the database")
+	seedValidateCommand.Flags().StringArrayVar(&seedVars, "var", nil, "set a seed variable as key=value, overriding the seed files")
+	seedValidateCommand.Flags().BoolVar(&seedUpsert, "upsert", false, "check that rows have the keys needed to upsert them")
+	seedCommand.AddCommand(seedValidateCommand)
+
+	for _, cmd := range []*cobra.Command{truncateCommand, seedCommand} {
+		cmd.Flags().StringSliceVar(&truncateInclude, "include", nil, "only truncate tables that match these patterns, instead of the config")
+		cmd.Flags().StringSliceVar(&truncateExclude, "exclude", nil, "keep tables that match these patterns, instead of the config")
+	}
+
+	restoreCommand.Flags().BoolVar(&forceProtected, "force-protected", false, "run against a database that is marked as protected")
+
+	for _, cmd := range []*cobra.Command{resetCommand, truncateCommand, seedCommand} {
+		cmd.Flags().BoolVar(&backupFirst, "backup", false, "back up the tables that will change before changing them")
+		cmd.Flags().BoolVar(&forceProtected, "force-protected", false, "run against a database that is marked as protected")
+	}
+
 	command.AddCommand(genCommand)
 	command.AddCommand(upCommand)
 	command.AddCommand(seedCommand)
 	command.AddCommand(resetCommand)
 	command.AddCommand(truncateCommand)
+	command.AddCommand(restoreCommand)
+	configCommand.AddCommand(configCheckCommand)
+	configCommand.AddCommand(configPathCommand)
+	command.AddCommand(configCommand)
+	command.AddCommand(lintCommand)
 
 	// defaults for config
 	viper.SetDefault("migrations", "./migrations")
@@ -88,12 +195,48 @@ func Run() {
 
 // generate a new migration file
 func gen(cmd *cobra.Command, args []string) {
-	MustLoadConfig(configFileName)
+	MustLoadConfig(configFileName, environmentName)
 	dbConfig := MustFindDBConfig(targetDatabase)
 	migrationPath := mustFindMigrationsPath(dbConfig)
-	migrationDesc := args[0]
+	migrationTemplate := mustLoadMigrationTemplate(dbConfig)
+
+	data := migrate.MigrationTemplateData{
+		Database: dbConfig.Name,
+		Author:   genAuthor,
+	}
+
+	var err error
+
+	switch {
+	case genCreateTable != "":
+		data.Desc = "create " + genCreateTable + " table"
+		data.SQL, err = migrate.ScaffoldCreateTable(dbConfig.Driver, genCreateTable)
+	case genAddColumn != "":
+		target := strings.SplitN(strings.Split(genAddColumn, ":")[0], ".", 2)
+		data.Desc = "add " + target[len(target)-1] + " to " + target[0]
+		data.SQL, err = migrate.ScaffoldAddColumn(dbConfig.Driver, genAddColumn)
+	case len(args) == 0:
+		err = fmt.Errorf("a description is required")
+	}
+
+	if err != nil {
+		color.Red(fmt.Sprintf("Error generating migration: %s", err.Error()))
+		return
+	}
+
+	if len(args) > 0 {
+		data.Desc = args[0]
+	}
+
+	existing := migrate.ListMigrationFiles(migrationPath, dbConfig.Versioning)
+	data.Version, err = migrate.NextVersion(dbConfig.Versioning, existing, genBump)
+
+	if err != nil {
+		color.Red(fmt.Sprintf("Error generating migration: %s", err.Error()))
+		return
+	}
 
-	err := migrate.GenerateMigration(migrationPath, migrationDesc)
+	err = migrate.GenerateMigrationFromTemplate(migrationPath, migrationTemplate, data)
 
 	if err != nil {
 		color.Red(fmt.Sprintf("Error generating migration: %s", err.Error()))
@@ -105,14 +248,14 @@ func gen(cmd *cobra.Command, args []string) {
 
 // apply migrations to the database if they are not in the migrations table.
 func up(cmd *cobra.Command, args []string) {
-	MustLoadConfig(configFileName)
+	MustLoadConfig(configFileName, environmentName)
 	MustLoadSecrets()
 	dbConfig := MustFindDBConfig(targetDatabase)
 	db := MustConnect(dbConfig)
 	defer db.Close()
 	mustHaveOrCreatedMigrationTable(db)
 	migrationsPath := mustFindMigrationsPath(dbConfig)
-	migrations := migrate.CheckMigrations(db, migrationsPath)
+	migrations := migrate.CheckVersionedMigrations(db, migrationsPath, dbConfig.Versioning)
 	indent := strconv.Itoa(FindLongestDesc(migrations) + INDENT)
 	willApply := 0
 
@@ -137,10 +280,14 @@ func up(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	err := migrate.ApplyMigrations(db, migrations)
+	options := applyOptions(dbConfig)
+	defer closeReplicas(options)
+
+	err := migrate.ApplyMigrationsWithOptions(db, migrations, options)
 
 	if err != nil {
 		color.Red(fmt.Sprintf("was not able to apply migrations: %s", err.Error()))
+		return
 	}
 
 	color.Green("All done 😎")
@@ -148,42 +295,163 @@ func up(cmd *cobra.Command, args []string) {
 
 // seed the selected database
 func seed(cmd *cobra.Command, args []string) {
-	MustLoadConfig(configFileName)
+	MustLoadConfig(configFileName, environmentName)
 	MustLoadSecrets()
 	dbConfig := MustFindDBConfig(targetDatabase)
 	db := MustConnect(dbConfig)
 	defer db.Close()
 
-	color.Red("*********************************************************")
-	color.Red("* This will destroy all data and replace with seed data *")
-	color.Red("*********************************************************")
+	files := MustFindSeedFiles(dbConfig, args)
+	options := migrate.SeedOptions{
+		Driver:         dbConfig.Driver,
+		Upsert:         seedUpsert,
+		RandomSeed:     seedRandom,
+		Truncate:       !seedUpsert && !seedOnce,
+		TruncateFilter: mustTableFilter(cmd, dbConfig),
+		BatchSize:      dbConfig.SeedBatchSize,
+		Track:          dbConfig.TrackSeeds,
+		Once:           seedOnce,
+		Root:           ConfigDir(),
+		Secret:         Secret,
+		Vars:           mustParseSeedVars(seedVars),
+		Progress: func(path string, skipped bool) {
+			if skipped {
+				color.Green(fmt.Sprintf("%s [ALREADY APPLIED]", path))
+			} else {
+				color.Yellow(fmt.Sprintf("%s [APPLYING]", path))
+			}
+		},
+	}
+
+	if seedBatchSize > 0 {
+		options.BatchSize = seedBatchSize
+	}
+
+	mustAllowDestructive(dbConfig, db, "seed")
+
+	switch {
+	case seedOnce:
+		color.Yellow("This will apply seed files that are new or have changed since they were last applied")
+	case seedUpsert:
+		color.Yellow("This will update existing rows and may delete rows from pruned seeds")
+	default:
+		color.Red("*********************************************************")
+		color.Red("* This will destroy all data and replace with seed data *")
+		color.Red("*********************************************************")
+	}
 
 	if !input.Confirm() {
 		fmt.Print("No further actions will take place.")
 		return
 	}
 
-	err := migrate.TruncateTables(db)
+	if wantsBackup(dbConfig) {
+		seedTables, err := migrate.SeedTables(files)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		mustBackup(dbConfig, db, "seed", func(table string) bool {
+			return SeedChangesTable(options, seedTables, table)
+		})
+	}
+
+	err := migrate.ApplySeedsWithOptions(db, files, options)
 
 	if err != nil {
-		color.Red(fmt.Sprintf("Error during initial truncate stage: %s", err.Error()))
+		color.Red(err.Error())
+		color.Red("Nothing was changed.")
+		os.Exit(1)
 	}
 
-	files := MustFindSeedFiles(args)
-	migrate.ApplySeeds(db, files)
 	color.Green("...all done 😎")
 }
 
+// export rows from the selected database to a seed file
+func seedExport(cmd *cobra.Command, args []string) {
+	MustLoadConfig(configFileName, environmentName)
+	MustLoadSecrets()
+	dbConfig := MustFindDBConfig(targetDatabase)
+
+	if dbConfig.Driver != "mysql" {
+		log.Fatal("seed export only supports mysql")
+	}
+
+	db := MustConnect(dbConfig)
+	defer db.Close()
+
+	input, err := migrate.ExportSeeds(db, migrate.ExportOptions{
+		Tables: exportTables,
+		Where:  exportWhere(exportTables, exportWheres),
+	})
+
+	if err == nil {
+		err = migrate.WriteSeedFile(args[0], input)
+	}
+
+	if err != nil {
+		color.Red(fmt.Sprintf("Error exporting seeds: %s", err.Error()))
+		os.Exit(1)
+	}
+
+	rows := 0
+
+	for s := range input.Seeds {
+		rows += len(input.Seeds[s].Insert)
+	}
+
+	color.Green(fmt.Sprintf("Exported %d rows to %s", rows, args[0]))
+}
+
+// check seed files for problems. Exits with a non-zero status if there are any, so that it can be used in CI.
+func seedValidate(cmd *cobra.Command, args []string) {
+	MustLoadConfig(configFileName, environmentName)
+	dbConfig := MustFindDBConfig(targetDatabase)
+	files := MustFindSeedFiles(dbConfig, args)
+	options := migrate.SeedOptions{
+		Driver: dbConfig.Driver,
+		Upsert: seedUpsert,
+		Vars:   mustParseSeedVars(seedVars),
+	}
+
+	var db *sql.DB
+
+	if !validateOffline {
+		MustLoadSecrets()
+		db = MustConnect(dbConfig)
+		defer db.Close()
+	}
+
+	problems, err := migrate.ValidateSeeds(db, files, options)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for p := range problems {
+		color.Yellow(problems[p].String())
+	}
+
+	if len(problems) > 0 {
+		color.Red(fmt.Sprintf("Found %d problems", len(problems)))
+		os.Exit(1)
+	}
+
+	color.Green(fmt.Sprintf("Checked %d seed files. All done 😎", len(files)))
+}
+
 // destroy all tables in database and reapply all migrations
 func reset(cmd *cobra.Command, args []string) {
 	var err error
 
-	MustLoadConfig(configFileName)
+	MustLoadConfig(configFileName, environmentName)
 	MustLoadSecrets()
 	dbConfig := MustFindDBConfig(targetDatabase)
 
 	db := MustConnect(dbConfig)
 	defer db.Close()
+	mustAllowDestructive(dbConfig, db, "reset")
 
 	migrationsPath := mustFindMigrationsPath(dbConfig)
 
@@ -196,6 +464,12 @@ func reset(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if wantsBackup(dbConfig) {
+		mustBackup(dbConfig, db, "reset", func(table string) bool {
+			return true
+		})
+	}
+
 	err = migrate.DropAllTables(db)
 
 	if err != nil {
@@ -205,8 +479,11 @@ func reset(cmd *cobra.Command, args []string) {
 	}
 
 	migrate.InitMigrationTable(db)
-	migrations := migrate.CheckMigrations(db, migrationsPath)
-	err = migrate.ApplyMigrations(db, migrations)
+	migrations := migrate.CheckVersionedMigrations(db, migrationsPath, dbConfig.Versioning)
+	options := applyOptions(dbConfig)
+	defer closeReplicas(options)
+
+	err = migrate.ApplyMigrationsWithOptions(db, migrations, options)
 
 	if err != nil {
 		color.Red("Was not able to complete migrations - your database is probably in a dire state.")
@@ -217,25 +494,210 @@ func reset(cmd *cobra.Command, args []string) {
 	color.Green("All done 😎")
 }
 
-// truncate all database tables.
+// check the config for mistakes. Exits with a non-zero status if there are any, so that it can be used in CI.
+// Without an environment, the config is checked on its own and then with each of its environments.
+func configCheck(cmd *cobra.Command, args []string) {
+	if err := LoadConfig(configFileName, environmentName); err != nil {
+		log.Fatal(err)
+	}
+
+	problems := ValidateConfig()
+	found := make(map[string]bool)
+
+	for p := range problems {
+		found[problems[p].String()] = true
+	}
+
+	if environmentName == "" {
+		for _, env := range Environments() {
+			if err := LoadConfig(configFileName, env); err != nil {
+				log.Fatal(err)
+			}
+
+			// only report the problems that the environment adds
+			for _, p := range ValidateConfig() {
+				if found[p.String()] {
+					continue
+				}
+
+				p.Key = "environments." + env + ": " + p.Key
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	for p := range problems {
+		color.Yellow(problems[p].String())
+	}
+
+	if len(problems) > 0 {
+		color.Red(fmt.Sprintf("Found %d problems in %s", len(problems), viper.ConfigFileUsed()))
+		os.Exit(1)
+	}
+
+	color.Green(fmt.Sprintf("%s looks good 😎", viper.ConfigFileUsed()))
+}
+
+// show which config file is loaded, or where it was searched for if there isn't one
+func configPath(cmd *cobra.Command, args []string) {
+	dir, err := os.Getwd()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	path, err := FindConfigFile(dir, configFileName)
+
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	fmt.Println(path)
+}
+
+// restore tables from a backup
+func restore(cmd *cobra.Command, args []string) {
+	MustLoadConfig(configFileName, environmentName)
+	MustLoadSecrets()
+	dbConfig := MustFindDBConfig(targetDatabase)
+	file, err := os.Open(args[0])
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer file.Close()
+
+	db := MustConnect(dbConfig)
+	defer db.Close()
+	mustAllowDestructive(dbConfig, db, "restore")
+
+	color.Red("************************************************************")
+	color.Red("* This will replace the tables in the backup with its data *")
+	color.Red("************************************************************")
+
+	if !input.Confirm() {
+		fmt.Print("No further actions will take place.")
+		return
+	}
+
+	if err := migrate.RestoreBackup(db, file); err != nil {
+		color.Red(fmt.Sprintf("Was not able to restore the backup: %s", err.Error()))
+		os.Exit(1)
+	}
+
+	color.Green("...all done 😎")
+}
+
+// the tables to truncate, from the flags if they were passed, otherwise from the config
+func mustTableFilter(cmd *cobra.Command, config DatabaseConfig) migrate.TableFilter {
+	filter := config.TableFilter()
+
+	if cmd.Flags().Changed("include") {
+		filter.Include = truncateInclude
+	}
+
+	if cmd.Flags().Changed("exclude") {
+		filter.Exclude = truncateExclude
+	}
+
+	if err := filter.Validate(); err != nil {
+		log.Fatal("bad truncate pattern: " + err.Error())
+	}
+
+	return filter
+}
+
+// truncate database tables, except for the ones that are kept by the config or flags.
 func truncate(cmd *cobra.Command, args []string) {
-	MustLoadConfig(configFileName)
+	MustLoadConfig(configFileName, environmentName)
 	MustLoadSecrets()
 	dbConfig := MustFindDBConfig(targetDatabase)
 	db := MustConnect(dbConfig)
 
 	defer db.Close()
 
-	color.Red("******************************")
-	color.Red("* This will destroy all data *")
-	color.Red("******************************")
+	mustAllowDestructive(dbConfig, db, "truncate")
+
+	filter := mustTableFilter(cmd, dbConfig)
+	tables, err := migrate.ListTables(db)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for t := range tables {
+		if filter.Match(tables[t]) {
+			color.Red(fmt.Sprintf("%s [TRUNCATE]", tables[t]))
+		} else {
+			color.Green(fmt.Sprintf("%s [KEEP]", tables[t]))
+		}
+	}
+
+	color.Red("**************************************************")
+	color.Red("* This will destroy all data in the tables above *")
+	color.Red("**************************************************")
 
 	if !input.ConfirmByTyping("destroy") {
 		fmt.Print("No further actions will take place.")
 		return
 	}
 
-	migrate.TruncateTables(db)
+	if wantsBackup(dbConfig) {
+		mustBackup(dbConfig, db, "truncate", filter.Match)
+	}
+
+	if err := migrate.TruncateTablesWithFilter(db, filter); err != nil {
+		log.Fatal(err)
+	}
 
 	color.Green("...all done 😎")
 }
+
+// check migrations that have not been applied yet for dangerous statements. Exits with a non-zero status if
+// there are any warnings, so that it can be used in CI.
+func lint(cmd *cobra.Command, args []string) {
+	MustLoadConfig(configFileName, environmentName)
+	dbConfig := MustFindDBConfig(targetDatabase)
+	migrationsPath := mustFindMigrationsPath(dbConfig)
+
+	var migrations []migrate.MigrationFile
+
+	if lintAll {
+		migrations = migrate.ListMigrationFiles(migrationsPath, dbConfig.Versioning)
+	} else {
+		migrations = mustFindPendingMigrations(dbConfig, migrationsPath)
+	}
+
+	warnings := 0
+
+	for m := range migrations {
+		if migrations[m].Applied {
+			continue
+		}
+
+		found, err := migrate.LintMigration(migrations[m].Path, dbConfig.LintDisable)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for w := range found {
+			color.Yellow(found[w].String())
+		}
+
+		warnings += len(found)
+	}
+
+	if warnings > 0 {
+		color.Red(fmt.Sprintf("Found %d warnings", warnings))
+		os.Exit(1)
+	}
+
+	color.Green("No warnings. All done 😎")
+}
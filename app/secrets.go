@@ -34,23 +34,25 @@ func LoadSecrets() error {
 	block := viper.GetStringMap("secrets")
 
 	for s := range block {
-		source := viper.Sub("secrets").GetStringMapString(s)
-
 		// If a source with the specified name already exists, do not allow import.
 		// This should not happen, since it would require a collision in the config file.
 		if _, alreadyExists := secretSources[s]; alreadyExists {
 			return NewErrSecretsAlreadyDefined(s)
 		}
 
-		var driver string
-		var uri string
-		var ok bool
+		if err := needEnv("secrets." + s); err != nil {
+			return err
+		}
+
+		// read each key on its own, so that environment variables can override them
+		driver := viper.GetString("secrets." + s + ".driver")
+		uri := viper.GetString("secrets." + s + ".uri")
 
-		if driver, ok = source["driver"]; !ok {
+		if driver == "" {
 			return fmt.Errorf("driver not present in secrets block for source:" + s)
 		}
 
-		if uri, ok = source["uri"]; !ok {
+		if uri == "" {
 			return fmt.Errorf("uri not present in secrets block for source:" + s)
 		}
 
@@ -62,6 +64,8 @@ func LoadSecrets() error {
 			err = loadAwsSMSecrets(s, uri)
 		case "json":
 			err = loadJsonSecrets(s, uri)
+		default:
+			err = NewErrBadConfig("unknown secret driver for source " + s + ": " + driver)
 		}
 
 		if err != nil {
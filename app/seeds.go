@@ -3,23 +3,145 @@ package app
 import (
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/Fantamstick/migrant/migrate"
 )
 
-// check each path to see if it's a file and return as array of SeedFile objects
-func MustFindSeedFiles(paths []string) []migrate.SeedFile {
+// MustFindSeedFiles resolves each argument to seed files and returns them as an array of SeedFile objects. An
+// argument can name a seed set from the database config, or be a file, a directory or a glob. Directories and
+// globs are expanded in file name order, so timestamped seed files are applied in the order they were made.
+func MustFindSeedFiles(config DatabaseConfig, args []string) []migrate.SeedFile {
 	files := make([]migrate.SeedFile, 0)
+	found := make(map[string]bool)
 
-	for p := range paths {
-		_, err := os.Stat(paths[p])
+	for a := range args {
+		paths, isSet := config.Seeds[args[a]]
 
-		if err != nil {
-			log.Fatal(err)
+		if !isSet {
+			paths = []string{args[a]}
 		}
 
-		files = append(files, migrate.SeedFile{Path: paths[p]})
+		for p := range paths {
+			for _, file := range mustExpandSeedPath(paths[p]) {
+				if found[file] {
+					continue
+				}
+
+				found[file] = true
+				files = append(files, migrate.SeedFile{Path: file})
+			}
+		}
 	}
 
 	return files
 }
+
+// expand a directory or glob into the seed files it contains, or check that a single file exists
+func mustExpandSeedPath(path string) []string {
+	info, err := os.Stat(path)
+
+	if err == nil && !info.IsDir() {
+		return []string{path}
+	}
+
+	pattern := path
+
+	if err == nil && info.IsDir() {
+		pattern = filepath.Join(path, "*")
+	}
+
+	matches, globErr := filepath.Glob(pattern)
+
+	if globErr != nil {
+		log.Fatal(globErr)
+	}
+
+	if len(matches) == 0 && err != nil {
+		log.Fatal(err)
+	}
+
+	files := make([]string, 0)
+
+	for m := range matches {
+		if migrate.IsSeedFile(matches[m]) {
+			files = append(files, matches[m])
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+
+	return files
+}
+
+// SeedChangesTable returns true if seeding with the options can change the table, because it is cleared before
+// seeding or because one of the seed tables is written to it.
+func SeedChangesTable(options migrate.SeedOptions, seedTables []string, table string) bool {
+	if options.Truncate && options.TruncateFilter.Match(table) {
+		return true
+	}
+
+	for s := range seedTables {
+		if seedTables[s] == table {
+			return true
+		}
+	}
+
+	return false
+}
+
+// build the conditions for exported rows. A condition that starts with the name of an exported table and a
+// colon, like "users:id < 100", only applies to that table. Any other condition applies to every table, and
+// several conditions for the same tables must all be true.
+func exportWhere(tables, where []string) map[string]string {
+	found := make(map[string][]string)
+
+	for w := range where {
+		parts := strings.SplitN(where[w], ":", 2)
+		table := strings.TrimSpace(parts[0])
+		perTable := false
+
+		for t := range tables {
+			perTable = perTable || (len(parts) == 2 && tables[t] == table)
+		}
+
+		if perTable {
+			found[table] = append(found[table], parts[1])
+		} else {
+			found[""] = append(found[""], where[w])
+		}
+	}
+
+	conditions := make(map[string]string)
+
+	for table, conds := range found {
+		if len(conds) == 1 {
+			conditions[table] = conds[0]
+		} else {
+			conditions[table] = "(" + strings.Join(conds, ") AND (") + ")"
+		}
+	}
+
+	return conditions
+}
+
+// parse the key=value pairs passed with --var
+func mustParseSeedVars(pairs []string) map[string]string {
+	vars := make(map[string]string)
+
+	for p := range pairs {
+		parts := strings.SplitN(pairs[p], "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			log.Fatal("variables must look like key=value, got: " + pairs[p])
+		}
+
+		vars[parts[0]] = parts[1]
+	}
+
+	return vars
+}
@@ -0,0 +1,55 @@
+package app_test
+
+import (
+	"testing"
+
+	"github.com/Fantamstick/migrant/app"
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustFindSeedFiles(t *testing.T) {
+	config := app.DatabaseConfig{
+		Seeds: map[string][]string{
+			"base": {"../fixtures/seeds0"},
+			"dev":  {"../fixtures/seeds0", "../fixtures/seeds4/*.csv"},
+		},
+	}
+
+	t.Run("it finds files in a seed set", func(t *testing.T) {
+		files := app.MustFindSeedFiles(config, []string{"dev"})
+		assert.Equal(t, []migrate.SeedFile{
+			{Path: "../fixtures/seeds0/20190101001122_seed_1.yaml"},
+			{Path: "../fixtures/seeds4/20190101001122_test_table_1.csv"},
+			{Path: "../fixtures/seeds4/20190102001122_links.csv"},
+		}, files, "should expand directories and globs in order")
+	})
+
+	t.Run("it finds files by path and skips duplicates", func(t *testing.T) {
+		files := app.MustFindSeedFiles(config, []string{"base", "../fixtures/seeds0/20190101001122_seed_1.yaml", "../fixtures/seeds2"})
+		assert.Equal(t, []migrate.SeedFile{
+			{Path: "../fixtures/seeds0/20190101001122_seed_1.yaml"},
+			{Path: "../fixtures/seeds2/20190101001122_users.yaml"},
+			{Path: "../fixtures/seeds2/20190102001122_links.yaml"},
+		}, files)
+	})
+}
+
+func TestSeedChangesTable(t *testing.T) {
+	seedTables := []string{"users", "countries"}
+
+	t.Run("it picks cleared tables and the tables seeds write to", func(t *testing.T) {
+		options := migrate.SeedOptions{Truncate: true, TruncateFilter: migrate.TableFilter{Exclude: []string{"countries"}}}
+
+		assert.True(t, app.SeedChangesTable(options, seedTables, "orders"), "should pick cleared tables")
+		assert.True(t, app.SeedChangesTable(options, seedTables, "countries"), "should pick kept tables that seeds write to")
+		assert.False(t, app.SeedChangesTable(options, seedTables, "migrations"), "should not pick tables that are left alone")
+	})
+
+	t.Run("it only picks the tables seeds write to without truncating", func(t *testing.T) {
+		options := migrate.SeedOptions{Upsert: true}
+
+		assert.True(t, app.SeedChangesTable(options, seedTables, "users"), "should pick seeded tables")
+		assert.False(t, app.SeedChangesTable(options, seedTables, "orders"), "should not pick other tables")
+	})
+}
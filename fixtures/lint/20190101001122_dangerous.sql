@@ -0,0 +1,7 @@
+-- a migration that does a little bit of everything it shouldn't
+ALTER TABLE users DROP COLUMN email;
+
+-- migrant:ignore alter_lock
+ALTER TABLE users ADD COLUMN name VARCHAR(32) NOT NULL, ALGORITHM=INPLACE;
+
+RENAME TABLE users TO people;
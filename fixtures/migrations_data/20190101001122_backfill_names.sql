@@ -0,0 +1,3 @@
+-- fill in names in small batches
+-- migrant:batch table=test_table_2 key=id size=2
+UPDATE test_table_2 SET name = CONCAT('name ', id) WHERE id >= :start AND id < :end;
@@ -0,0 +1,12 @@
+-- adds a column to the test table without locking it
+CREATE TABLE test_table_3 (id INT NOT NULL);
+
+-- migrant:online
+ALTER TABLE test_table_2 ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT '';
+
+-- statements with compound bodies are run as they are
+CREATE TRIGGER test_table_3_ins BEFORE INSERT ON test_table_3 FOR EACH ROW
+BEGIN
+    SET NEW.id = NEW.id + 1;
+    SET NEW.id = NEW.id * 2;
+END;
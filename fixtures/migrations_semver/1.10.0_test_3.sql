@@ -0,0 +1 @@
+-- 1.10.0_test_3
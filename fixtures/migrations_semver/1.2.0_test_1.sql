@@ -0,0 +1 @@
+-- 1.2.0_test_1
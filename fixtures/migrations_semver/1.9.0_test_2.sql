@@ -0,0 +1 @@
+-- 1.9.0_test_2
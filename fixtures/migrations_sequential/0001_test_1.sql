@@ -0,0 +1 @@
+-- 0001_test_1
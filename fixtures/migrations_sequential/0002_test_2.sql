@@ -0,0 +1 @@
+-- 0002_test_2
@@ -0,0 +1 @@
+-- 0010_test_3
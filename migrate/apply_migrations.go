@@ -2,12 +2,33 @@ package migrate
 
 import (
 	"database/sql"
+	"fmt"
 	"io/ioutil"
 )
 
+// ApplyOptions controls how online statements and data migrations are applied.
+type ApplyOptions struct {
+	Online OnlineOptions
+	Batch  BatchOptions
+}
+
+// DefaultApplyOptions returns the options used when none are configured.
+func DefaultApplyOptions() ApplyOptions {
+	return ApplyOptions{
+		Online: DefaultOnlineOptions(),
+		Batch:  DefaultBatchOptions(),
+	}
+}
+
 // ApplyMigrations takes an array of migration files. If the file is not yet apply
 // it will run the contents against the current db.
 func ApplyMigrations(db *sql.DB, migrations []MigrationFile) error {
+	return ApplyMigrationsWithOptions(db, migrations, DefaultApplyOptions())
+}
+
+// ApplyMigrationsWithOptions works like ApplyMigrations, using the passed options for any ALTER TABLE
+// statements that are marked with a `-- migrant:online` comment and for data migrations.
+func ApplyMigrationsWithOptions(db *sql.DB, migrations []MigrationFile, options ApplyOptions) error {
 	for m := range migrations {
 		if migrations[m].Applied {
 			continue
@@ -19,7 +40,14 @@ func ApplyMigrations(db *sql.DB, migrations []MigrationFile) error {
 			return err
 		}
 
-		_, err = db.Exec(string(sql))
+		switch {
+		case HasBatchDirective(string(sql)):
+			err = RunDataMigration(db, migrations[m].Prefix, string(sql), options.Batch)
+		case HasOnlineStatements(string(sql)):
+			err = applyOnlineStatements(db, string(sql), options.Online)
+		default:
+			_, err = db.Exec(string(sql))
+		}
 
 		if err != nil {
 			return err
@@ -34,3 +62,44 @@ func ApplyMigrations(db *sql.DB, migrations []MigrationFile) error {
 
 	return nil
 }
+
+// apply alter statements that are marked as online without locking. The sql between them is run as it is, the
+// same way as a migration without online statements, so that triggers and procedures are not split apart.
+func applyOnlineStatements(db *sql.DB, sql string, options OnlineOptions) error {
+	statements := splitStatements(sql)
+	last := 0
+
+	for s := range statements {
+		if !onlineMarker.MatchString(statements[s].comments) {
+			continue
+		}
+
+		matches := onlineAlter.FindStringSubmatch(statements[s].sql)
+
+		if matches == nil {
+			return fmt.Errorf("only ALTER TABLE statements can be run online (line %d)", statements[s].line)
+		}
+
+		if err := execSQL(db, sql[last:statements[s].start]); err != nil {
+			return err
+		}
+
+		if err := OnlineAlterTable(db, matches[1], matches[2], options); err != nil {
+			return err
+		}
+
+		last = statements[s].end
+	}
+
+	return execSQL(db, sql[last:])
+}
+
+// run sql as it is, unless it has nothing but comments in it
+func execSQL(db *sql.DB, sql string) error {
+	if len(splitStatements(sql)) == 0 {
+		return nil
+	}
+
+	_, err := db.Exec(sql)
+	return err
+}
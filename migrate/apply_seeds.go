@@ -3,14 +3,17 @@ package migrate
 import (
 	"bytes"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"math/rand"
+	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
 	"golang.org/x/crypto/bcrypt"
-	yaml "gopkg.in/yaml.v2"
 )
 
 type SeedFile struct {
@@ -18,99 +21,677 @@ type SeedFile struct {
 }
 
 type SeedInput struct {
-	Vars  map[string]string
-	Seeds []Seed
+	Include    []string          `yaml:"include,omitempty"`
+	VarsFiles  []string          `yaml:"vars_files,omitempty" json:"vars_files"`
+	Vars       map[string]string `yaml:"vars,omitempty"`
+	RandomSeed *int64            `yaml:"random_seed,omitempty" json:"random_seed"`
+	Seeds      []Seed            `yaml:"seeds"`
+}
+
+// Keys of a seed row that are directives rather than columns. They start with an underscore so that they never
+// clash with real columns, like a column called count.
+const (
+	RefKey   = "_ref"   // names the row, so other rows can refer to its id with the ref helper
+	CountKey = "_count" // inserts the row this many times
+)
+
+// RowData is available to the templates of a seed row. Index counts up from 0 for rows that are repeated.
+type RowData struct {
+	Index int
 }
 
 type Seed struct {
-	Table  string
-	Insert []map[string]string
+	Table  string    `yaml:"table"`
+	Keys   []string  `yaml:"keys,omitempty"`
+	Prune  bool      `yaml:"prune,omitempty"`
+	Insert []SeedRow `yaml:"insert"`
+}
+
+// SeedOptions controls how seeds are applied.
+type SeedOptions struct {
+	Driver     string // the database driver, used to pick the right sql dialect
+	Upsert     bool   // update existing rows that match the keys of a seed instead of inserting them
+	RandomSeed int64  // seeds the fake data helpers, unless a seed file sets its own random_seed
+	Truncate   bool   // clear every table except migrant's own tables before seeding
+
+	// picks the tables that are cleared when truncating, every table if empty
+	TruncateFilter TableFilter
+	BatchSize      int  // the most rows inserted by a single statement, DefaultSeedBatchSize if not set
+	Track          bool // record applied seed files and their checksums in the seed history table
+	Once           bool // skip seed files that were recorded with the same checksum before, implies Track
+
+	// the directory that seed files are named relative to in the seed history, like the directory of the config
+	Root string
+
+	// variables that override the variables of every seed file
+	Vars map[string]string
+
+	// resolves secret uris for the secret helper
+	Secret func(uri string) (string, error)
+
+	// called for each seed file, with skipped set if it was already applied
+	Progress func(path string, skipped bool)
 }
 
-// ApplySeeds reads an array of seed files and applies them to the database.
+// ApplySeeds reads an array of seed files and applies them to the database. Logs a fatal if any of them fail,
+// use ApplySeedsWithOptions to get the error instead.
 func ApplySeeds(db *sql.DB, seedFiles []SeedFile) {
+	if err := ApplySeedsWithOptions(db, seedFiles, SeedOptions{Driver: "mysql"}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ApplySeedsWithOptions reads an array of seed files and applies them to the database. Files that a seed file
+// includes are applied before it. When upserting, seeds
+// that declare key columns insert rows that are new and update rows that already exist, and seeds that are
+// marked to be pruned delete any rows that are not in the seed.
+//
+// Rows for the same table and columns are inserted together, in batches of up to BatchSize rows. Rows that
+// need the id of a row that is still waiting in a batch cause that batch to be inserted first.
+//
+// When tracking, every applied file is recorded in the seed history table, and with Once, files that were
+// applied before and have not changed since are skipped.
+//
+// The whole run, including clearing tables, happens in a single transaction. If anything fails the database is
+// left untouched, and the error says which file, seed and column it failed on.
+func ApplySeedsWithOptions(db *sql.DB, seedFiles []SeedFile, options SeedOptions) error {
+	options.Track = options.Track || options.Once
+
+	if options.Track {
+		if err := InitSeedHistoryTable(db); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	err = applySeeds(tx, seedFiles, options)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// apply seeds inside of a transaction
+func applySeeds(tx *sql.Tx, seedFiles []SeedFile, options SeedOptions) error {
+	if options.Truncate {
+		if err := clearTables(tx, options.Driver, options.TruncateFilter); err != nil {
+			return err
+		}
+	}
+
+	collectedRefs := make(map[string]map[string]int64) // will hold the ids of named rows from every seed file
+	declaredRefs := make(map[string]map[string]bool)   // names of rows that are inserted or waiting in a batch
+	var collectedIds map[string][]int64                // will hold the ids that are generated during the seed process
+
+	batch, err := newSeedBatch(tx, options.Driver, options.BatchSize, func(table string, id int64, ref string) {
+		collectedIds[table] = append(collectedIds[table], id)
+
+		if ref != "" {
+			if _, ok := collectedRefs[table]; !ok {
+				collectedRefs[table] = make(map[string]int64)
+			}
+
+			collectedRefs[table][ref] = id
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if seedFiles, err = ExpandSeedIncludes(seedFiles); err != nil {
+		return err
+	}
+
+	applied := make(map[string]string)
 
-	// read yaml file
+	if options.Once {
+		if applied, err = appliedSeeds(tx); err != nil {
+			return err
+		}
+	}
+
+	// read seed file
 	for s := range seedFiles {
-		file, err := ioutil.ReadFile(seedFiles[s].Path)
+		path := seedFiles[s].Path
+		checksum, err := SeedChecksum(path)
 
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+
+		skipped := options.Once && applied[historyName(options.Root, path)] == checksum
+
+		if options.Progress != nil {
+			options.Progress(path, skipped)
+		}
+
+		if skipped {
+			continue
 		}
 
-		var input SeedInput
-		err = yaml.Unmarshal(file, &input)
+		input, err := ReadSeedFile(path)
 
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
-		collectedIds := make(map[string][]int64) //  will hold the ids that are generated during the seed process
+		vars, err := loadSeedVars(path, input, options.Vars)
+
+		if err != nil {
+			return err
+		}
+
+		collectedIds = make(map[string][]int64)
 
 		f := template.FuncMap{
-			"id": func(source string, index int) string {
-				return fmt.Sprint(collectedIds[source][index])
+			"id": func(source string, index int) (string, error) {
+				if index >= len(collectedIds[source]) && batch.pending() {
+					if err := batch.flush(); err != nil {
+						return "", err
+					}
+				}
+				if index < 0 || index >= len(collectedIds[source]) {
+					return "", fmt.Errorf("no id at index %d for table %s (%d ids collected)", index, source, len(collectedIds[source]))
+				}
+				return fmt.Sprint(collectedIds[source][index]), nil
 			},
-			"var": func(source string) string {
-				return fmt.Sprint(input.Vars[source])
+			"ref": func(source, name string) (string, error) {
+				if declaredRefs[source][name] && batch.pending() {
+					if err := batch.flush(); err != nil {
+						return "", err
+					}
+				}
+				id, ok := collectedRefs[source][name]
+				if !ok {
+					return "", fmt.Errorf("undefined reference %q for table %s", name, source)
+				}
+				return fmt.Sprint(id), nil
 			},
-			"bcrypt": func(source string) string {
+			"bcrypt": func(source string) (string, error) {
 				hashed, err := bcrypt.GenerateFromPassword([]byte(source), 10)
-				if err != nil {
-					log.Fatal(err)
-				}
-				return string(hashed)
+				return string(hashed), err
 			},
 		}
 
+		randomSeed := options.RandomSeed
+
+		if input.RandomSeed != nil {
+			randomSeed = *input.RandomSeed
+		}
+
+		for name, fn := range fakeFuncs(rand.New(rand.NewSource(randomSeed))) {
+			f[name] = fn
+		}
+
+		for name, fn := range utilFuncs(filepath.Dir(path), options.Secret) {
+			f[name] = fn
+		}
+
+		f["var"] = varFunc(vars, f)
+
 		for set := range input.Seeds {
-			table := input.Seeds[set].Table
+			seed := input.Seeds[set]
+			table := seed.Table
+			upsert := options.Upsert && len(seed.Keys) > 0
+			seen := make([][]interface{}, 0) // key values of each row, used for pruning
+
+			if upsert {
+				if err := checkUniqueKeys(tx, table, seed.Keys); err != nil {
+					return NewErrSeed(path, set, "", err)
+				}
+			}
 
 			// make sure there's an array to collect ids
 			if _, ok := collectedIds[table]; !ok {
 				collectedIds[table] = make([]int64, 0)
 			}
 
-			if err != nil {
-				log.Fatal(err)
-			}
-
 			// for each insert, collect the cols and vals. Run each val as a template to get its computed value.
-			for i := range input.Seeds[set].Insert {
-				insert := input.Seeds[set].Insert[i]
+			for i := range seed.Insert {
+				insert := seed.Insert[i]
+
+				count, err := rowCount(insert)
+
+				if err != nil {
+					return NewErrSeed(path, set, CountKey, err)
+				}
+
+				for n := 0; n < count; n++ {
+					cols := make([]string, 0)
+					vals := make([]interface{}, 0)
+
+					// sort columns so that statements are the same for every row
+					for colName := range insert {
+						if colName != RefKey && colName != CountKey {
+							cols = append(cols, colName)
+						}
+					}
+
+					ref, hasRef := rowRef(insert, n)
+
+					if hasRef {
+						if declaredRefs[table][ref] {
+							return NewErrSeed(path, set, RefKey, fmt.Errorf("reference %q is already defined for table %s", ref, table))
+						}
+
+						if _, ok := declaredRefs[table]; !ok {
+							declaredRefs[table] = make(map[string]bool)
+						}
+
+						declaredRefs[table][ref] = true
+					}
+
+					sort.Strings(cols)
+
+					for c := range cols {
+						val, err := resolveSeedValue(cols[c], insert[cols[c]], f, RowData{Index: n})
+
+						if err != nil {
+							return NewErrSeed(path, set, cols[c], err)
+						}
+
+						// store the computed value
+						vals = append(vals, val)
+					}
+
+					if !upsert {
+						if err := batch.add(table, cols, vals, ref); err != nil {
+							return NewErrSeed(path, set, "", err)
+						}
+
+						continue
+					}
+
+					keyVals, err := keyValues(seed.Keys, cols, vals)
 
-				cols := make([]string, 0)
-				vals := make([]interface{}, 0)
-				qs := make([]string, 0)
+					if err != nil {
+						return NewErrSeed(path, set, "", err)
+					}
 
-				for colName, valTemplate := range insert {
-					t := template.Must(template.New(colName).Funcs(f).Parse(valTemplate))
-					var buf bytes.Buffer
-					err := t.Execute(&buf, nil)
+					seen = append(seen, keyVals)
+					res, err := upsertRow(tx, options.Driver, table, seed.Keys, cols, vals)
 
 					if err != nil {
-						log.Fatal(err)
+						return NewErrSeed(path, set, "", err)
 					}
 
-					// store the column name and computer value
-					cols = append(cols, colName)
-					vals = append(vals, buf.String())
-					qs = append(qs, "?")
+					if lastId, err := res.LastInsertId(); err == nil {
+						batch.record(table, lastId, ref)
+					}
 				}
+			}
 
-				q := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(cols, ", "), strings.Join(qs, ", "))
-				res, err := db.Exec(q, vals...)
+			// insert what is left, so that errors are reported for the seed they happened in
+			if err := batch.flush(); err != nil {
+				return NewErrSeed(path, set, "", err)
+			}
+
+			if upsert && seed.Prune {
+				err := pruneRows(tx, table, seed.Keys, seen)
 
 				if err != nil {
-					log.Fatal(err)
+					return NewErrSeed(path, set, "", err)
 				}
+			}
+		}
 
-				lastId, err := res.LastInsertId()
+		if options.Track {
+			if err := recordSeed(tx, historyName(options.Root, path), checksum); err != nil {
+				return err
+			}
+		}
+	}
 
-				if err == nil {
-					collectedIds[table] = append(collectedIds[table], lastId)
-				}
+	return nil
+}
+
+// delete everything from the tables that the filter picks, which never includes the migration table. Mysql
+// cannot truncate inside of a transaction, so rows are deleted with foreign key checks switched off for the
+// transaction's connection.
+func clearTables(tx *sql.Tx, driver string, filter TableFilter) error {
+	if driver != "mysql" {
+		return fmt.Errorf("cannot clear tables for driver: %s", driver)
+	}
+
+	rows, err := tx.Query("SHOW TABLES")
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	tables := make([]string, 0)
+
+	for rows.Next() {
+		var t string
+
+		if err := rows.Scan(&t); err != nil {
+			return err
+		}
+
+		if filter.Match(t) {
+			tables = append(tables, t)
+		}
+	}
+
+	rows.Close()
+
+	if len(tables) == 0 {
+		return nil
+	}
+
+	statements := []string{"SET FOREIGN_KEY_CHECKS = 0"}
+
+	for t := range tables {
+		statements = append(statements, "DELETE FROM `"+tables[t]+"`")
+	}
+
+	if err := execAll(tx, statements...); err != nil {
+		// the setting belongs to the connection, which goes back to the pool after the rollback
+		tx.Exec("SET FOREIGN_KEY_CHECKS = 1")
+		return err
+	}
+
+	return execAll(tx, "SET FOREIGN_KEY_CHECKS = 1")
+}
+
+// insert a row, or update the existing row with the same keys. For mysql, the last insert id of the result is
+// the id of the row whether it was inserted or updated.
+func upsertRow(db execer, driver, table string, keys, cols []string, vals []interface{}) (sql.Result, error) {
+	updates := make([]string, 0)
+
+	switch driver {
+	case "mysql":
+		pk, err := autoIncrementColumn(db, table)
+
+		if err != nil {
+			return nil, err
+		}
+
+		quoted := make([]string, len(cols))
+
+		for c := range cols {
+			quoted[c] = "`" + cols[c] + "`"
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", quoted[c], quoted[c]))
+		}
+
+		// makes the id of an updated row available as the last insert id
+		if pk != "" {
+			updates = append(updates, fmt.Sprintf("`%s` = LAST_INSERT_ID(`%s`)", pk, pk))
+		}
+
+		values, args := valuesClause(vals)
+		q := fmt.Sprintf(
+			"INSERT INTO `%s` (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+			table, strings.Join(quoted, ", "), values, strings.Join(updates, ", "),
+		)
+
+		return db.Exec(q, args...)
+	}
+
+	return nil, fmt.Errorf("cannot upsert seeds for driver: %s", driver)
+}
+
+// delete every row whose keys are not in the list of seen keys, which is every row if none were seen
+func pruneRows(db execer, table string, keys []string, seen [][]interface{}) error {
+	if len(seen) == 0 {
+		_, err := db.Exec("DELETE FROM `" + table + "`")
+		return err
+	}
+
+	quoted := make([]string, len(keys))
+
+	for k := range keys {
+		quoted[k] = "`" + keys[k] + "`"
+	}
+
+	tuples := make([]string, len(seen))
+	args := make([]interface{}, 0)
+
+	for s := range seen {
+		tuples[s] = "(" + placeholders(len(keys)) + ")"
+		args = append(args, seen[s]...)
+	}
+
+	q := fmt.Sprintf("DELETE FROM `%s` WHERE (%s) NOT IN (%s)", table, strings.Join(quoted, ", "), strings.Join(tuples, ", "))
+	_, err := db.Exec(q, args...)
+
+	return err
+}
+
+// pick the values of the key columns out of a row
+func keyValues(keys, cols []string, vals []interface{}) ([]interface{}, error) {
+	keyVals := make([]interface{}, 0)
+
+	for k := range keys {
+		found := false
+
+		for c := range cols {
+			if _, isSQL := vals[c].(SQLValue); isSQL && cols[c] == keys[k] {
+				return nil, fmt.Errorf("key column %s cannot be raw sql", keys[k])
+			}
+
+			if cols[c] == keys[k] {
+				keyVals = append(keyVals, vals[c])
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("seed row is missing key column %s", keys[k])
+		}
+	}
+
+	return keyVals, nil
+}
+
+// find the auto increment column of a mysql table, if it has one
+func autoIncrementColumn(db execer, table string) (string, error) {
+	var col string
+
+	err := db.QueryRow(`
+		SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND EXTRA LIKE '%auto_increment%'
+	`, table).Scan(&col)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return col, err
+}
+
+// make sure that a unique index of a mysql table is made of exactly the key columns of a seed. Upserts rely on
+// ON DUPLICATE KEY, so without one, rows with the same keys would be inserted again instead of updated.
+func checkUniqueKeys(db execer, table string, keys []string) error {
+	rows, err := db.Query(`
+		SELECT INDEX_NAME, COLUMN_NAME FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND NON_UNIQUE = 0
+	`, table)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	indexes := make(map[string][]string)
+
+	for rows.Next() {
+		var index, col string
+
+		if err := rows.Scan(&index, &col); err != nil {
+			return err
+		}
+
+		indexes[index] = append(indexes[index], col)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, cols := range indexes {
+		if len(cols) != len(keys) {
+			continue
+		}
+
+		covered := true
+
+		for k := range keys {
+			covered = covered && contains(cols, keys[k])
+		}
+
+		if covered {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("keys (%s) of table %s are not covered by a unique index", strings.Join(keys, ", "), table)
+}
+
+// a comma separated list of n placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// compute the value that gets inserted for a seed value. Strings and json documents are templates, which are
+// executed with the passed helpers.
+func resolveSeedValue(col string, val interface{}, funcs template.FuncMap, data RowData) (interface{}, error) {
+	switch v := val.(type) {
+	case string:
+		return executeSeedTemplate(col, v, funcs, data)
+
+	case JSONValue:
+		return resolveJSONValue(col, v, funcs, data)
+
+	case Base64Value:
+		decoded, err := base64.StdEncoding.DecodeString(string(v))
+
+		if err != nil {
+			return nil, fmt.Errorf("column %s is not valid base64: %s", col, err)
+		}
+
+		return decoded, nil
+	}
+
+	return val, nil
+}
+
+// compute the json document that gets inserted for a json value. Documents that are json already, like the ones
+// written as yaml, have the templates in their strings executed one by one, so that the quotes in them are not
+// escaped and template output cannot break the document. Anything else is executed as a whole and must be json
+// afterwards.
+func resolveJSONValue(col string, val JSONValue, funcs template.FuncMap, data RowData) (interface{}, error) {
+	if !strings.Contains(string(val), "{{") {
+		if !json.Valid([]byte(val)) {
+			return nil, fmt.Errorf("column %s is not valid json: %s", col, val)
+		}
+
+		return string(val), nil
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(val)))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		text, err := executeSeedTemplate(col, string(val), funcs, data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !json.Valid([]byte(text)) {
+			return nil, fmt.Errorf("column %s is not valid json: %s", col, text)
+		}
+
+		return text, nil
+	}
+
+	doc, err := executeJSONTemplates(col, doc, funcs, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// execute the templates in every string of a decoded json document
+func executeJSONTemplates(col string, doc interface{}, funcs template.FuncMap, data RowData) (interface{}, error) {
+	var err error
+
+	switch v := doc.(type) {
+	case string:
+		return executeSeedTemplate(col, v, funcs, data)
+
+	case map[string]interface{}:
+		for key := range v {
+			if v[key], err = executeJSONTemplates(col, v[key], funcs, data); err != nil {
+				return nil, err
 			}
 		}
+
+	case []interface{}:
+		for i := range v {
+			if v[i], err = executeJSONTemplates(col, v[i], funcs, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return doc, nil
+}
+
+// execute a single template
+func executeSeedTemplate(name, text string, funcs template.FuncMap, data RowData) (string, error) {
+	t, err := template.New(name).Funcs(funcs).Parse(text)
+
+	if err != nil {
+		return "", err
 	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+
+	return buf.String(), err
+}
+
+// build the list of values for an insert statement. Raw sql values are written into the list, everything
+// else becomes a placeholder.
+func valuesClause(vals []interface{}) (string, []interface{}) {
+	exprs := make([]string, len(vals))
+	args := make([]interface{}, 0)
+
+	for v := range vals {
+		if expr, isSQL := vals[v].(SQLValue); isSQL {
+			exprs[v] = string(expr)
+			continue
+		}
+
+		args = append(args, vals[v])
+		exprs[v] = "?"
+	}
+
+	return strings.Join(exprs, ", "), args
 }
@@ -2,7 +2,10 @@ package migrate_test
 
 import (
 	"database/sql"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/Fantamstick/migrant/migrate"
@@ -100,3 +103,385 @@ func TestApplySeeds(t *testing.T) {
 		assert.Nil(t, bcrypt.CompareHashAndPassword(linkTables[1].Bcrypt, []byte("secret")), "should decrypt hashed string")
 	})
 }
+
+func TestApplySeedsUpsert(t *testing.T) {
+	mustExec(`
+		CREATE TABLE countries (
+			id INT AUTO_INCREMENT,
+			code VARCHAR(2) NOT NULL,
+			name VARCHAR(32),
+			PRIMARY KEY (id),
+			UNIQUE KEY (code)
+		);
+	`, `INSERT INTO countries (code, name) VALUES ("JP", "Nippon"), ("FR", "France")`)
+
+	defer mustExec("DROP TABLE IF EXISTS countries")
+
+	seeds := []migrate.SeedFile{
+		{
+			Path: "../fixtures/seeds1/20190101001122_countries.yaml",
+		},
+	}
+
+	t.Run("it upserts and prunes rows", func(t *testing.T) {
+		migrate.ApplySeedsWithOptions(db, seeds, migrate.SeedOptions{Driver: "mysql", Upsert: true})
+
+		var id int64
+		var name string
+		err := db.QueryRow(`SELECT id, name FROM countries WHERE code = "JP"`).Scan(&id, &name)
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(1), id, "should keep the existing row")
+		assert.Equal(t, "Japan", name, "should update the existing row")
+
+		assert.Equal(t, int64(2), getRowCount("countries"), "should insert new rows and prune missing ones")
+		assert.Equal(t, int64(0), getRowCount(`countries WHERE code = "FR"`), "should prune rows not in the seed")
+	})
+
+	t.Run("it upserts and prunes tables and columns named after keywords", func(t *testing.T) {
+		mustExec("CREATE TABLE `order` (`id` INT AUTO_INCREMENT, `key` VARCHAR(32), `value` VARCHAR(32), PRIMARY KEY (`id`), UNIQUE KEY (`key`))")
+		defer mustExec("DROP TABLE IF EXISTS `order`")
+
+		mustExec("INSERT INTO `order` (`key`, `value`) VALUES (\"theme\", \"light\"), (\"old\", \"gone\")")
+
+		err := migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds12/20190101001122_settings.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql", Upsert: true})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(1), getRowCount("`order` WHERE `key` = \"theme\" AND `value` = \"dark\""), "should update rows")
+		assert.Equal(t, int64(2), getRowCount("`order`"), "should insert new rows and prune missing ones")
+
+		err = migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds12/20190102001122_empty.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql", Upsert: true})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(0), getRowCount("`order`"), "should prune every row of an empty seed")
+	})
+
+	t.Run("it refuses keys without a unique index", func(t *testing.T) {
+		mustExec("ALTER TABLE countries DROP INDEX code")
+
+		err := migrate.ApplySeedsWithOptions(db, seeds, migrate.SeedOptions{Driver: "mysql", Upsert: true})
+		assert.NotNil(t, err, "should return an error")
+		assert.Contains(t, err.Error(), "keys (code) of table countries are not covered by a unique index", "should name the keys")
+		assert.Equal(t, int64(2), getRowCount("countries"), "should not change any rows")
+	})
+}
+
+func TestApplySeedsRefs(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	seeds := []migrate.SeedFile{
+		{Path: "../fixtures/seeds2/20190101001122_users.yaml"},
+		{Path: "../fixtures/seeds2/20190102001122_links.yaml"},
+	}
+
+	t.Run("it resolves named references across seed files", func(t *testing.T) {
+		migrate.ApplySeeds(db, seeds)
+
+		var bobID, linkedID int64
+		err := db.QueryRow(`SELECT id FROM test_table_1 WHERE name = "bob"`).Scan(&bobID)
+		assert.Nil(t, err, "should not return an error")
+
+		err = db.QueryRow(`SELECT test_table_id FROM link_table_1`).Scan(&linkedID)
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, bobID, linkedID, "should seed the id of the named row")
+	})
+
+	t.Run("it names every copy of a counted row", func(t *testing.T) {
+		err := migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds11/20190101001122_counted.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql"})
+		assert.Nil(t, err, "should not return an error")
+
+		var bulkID, linkedID int64
+		err = db.QueryRow(`SELECT id FROM test_table_1 WHERE name = "bulk 1"`).Scan(&bulkID)
+		assert.Nil(t, err, "should not return an error")
+
+		err = db.QueryRow(`SELECT test_table_id FROM link_table_1 WHERE foo = "bulk link"`).Scan(&linkedID)
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, bulkID, linkedID, "should seed the id of the named copy")
+	})
+}
+
+func TestApplySeedsFakeData(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	seeds := []migrate.SeedFile{
+		{Path: "../fixtures/seeds3/20190101001122_fake.yaml"},
+	}
+
+	names := func() []string {
+		rows, err := db.Query("SELECT foo FROM link_table_1 ORDER BY id")
+		assert.Nil(t, err, "should not return an error")
+		defer rows.Close()
+
+		found := make([]string, 0)
+		for rows.Next() {
+			var name string
+			rows.Scan(&name)
+			found = append(found, name)
+		}
+		return found
+	}
+
+	t.Run("it repeats rows and generates the same data every time", func(t *testing.T) {
+		migrate.ApplySeeds(db, seeds)
+
+		assert.Equal(t, int64(5), getRowCount("test_table_1"), "should insert the row 5 times")
+		assert.Equal(t, int64(1), getRowCount(`test_table_1 WHERE name LIKE "5 %"`), "should count up sequences")
+
+		first := names()
+		assert.Len(t, first, 3, "should insert the row 3 times")
+
+		mustExec("DELETE FROM link_table_1", "DELETE FROM test_table_1")
+		migrate.ApplySeeds(db, seeds)
+
+		assert.Equal(t, first, names(), "should generate the same names")
+	})
+}
+
+func TestApplySeedsTransaction(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	mustExec(`INSERT INTO test_table_1 (name) VALUES ("existing")`)
+
+	t.Run("it leaves the database untouched on error", func(t *testing.T) {
+		err := migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds6/20190101001122_broken.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql", Truncate: true})
+
+		assert.NotNil(t, err, "should return an error")
+		assert.Contains(t, err.Error(), "20190101001122_broken.yaml, seed 1, column test_table_id", "should say where it failed")
+		assert.Equal(t, int64(1), getRowCount(`test_table_1 WHERE name = "existing"`), "should not truncate")
+		assert.Equal(t, int64(1), getRowCount("test_table_1"), "should not insert")
+	})
+
+	t.Run("it clears tables and seeds in one go", func(t *testing.T) {
+		err := migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds0/20190101001122_seed_1.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql", Truncate: true})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(0), getRowCount(`test_table_1 WHERE name = "existing"`), "should clear existing rows")
+		assert.Equal(t, int64(1), getRowCount("test_table_1"), "should seed rows")
+	})
+
+	t.Run("it keeps counting ids after clearing tables", func(t *testing.T) {
+		seeds := []migrate.SeedFile{{Path: "../fixtures/seeds0/20190101001122_seed_1.yaml"}}
+		lastID := func() int64 {
+			var id int64
+			assert.Nil(t, db.QueryRow("SELECT MAX(id) FROM test_table_1").Scan(&id), "should not return an error")
+			return id
+		}
+
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, seeds, migrate.SeedOptions{Driver: "mysql", Truncate: true}), "should not return an error")
+		first := lastID()
+
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, seeds, migrate.SeedOptions{Driver: "mysql", Truncate: true}), "should not return an error")
+		assert.True(t, lastID() > first, "should not reset auto increment counters")
+	})
+
+	t.Run("it turns foreign key checks back on when clearing fails", func(t *testing.T) {
+		// a single connection, so that the one that cleared is the one that is checked
+		db.SetMaxOpenConns(1)
+		defer db.SetMaxOpenConns(0)
+
+		mustExec(`CREATE TRIGGER refuse_delete BEFORE DELETE ON test_table_1 FOR EACH ROW SIGNAL SQLSTATE '45000'`)
+		defer mustExec("DROP TRIGGER IF EXISTS refuse_delete")
+
+		err := migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds0/20190101001122_seed_1.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql", Truncate: true})
+
+		assert.NotNil(t, err, "should return an error")
+
+		var checks int
+		assert.Nil(t, db.QueryRow("SELECT @@FOREIGN_KEY_CHECKS").Scan(&checks), "should not return an error")
+		assert.Equal(t, 1, checks, "should turn foreign key checks back on")
+	})
+}
+
+func TestApplySeedsBatches(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	seeds := []migrate.SeedFile{
+		{Path: "../fixtures/seeds7/20190101001122_batch.yaml"},
+	}
+
+	linkedName := func(foo string) string {
+		var name string
+		err := db.QueryRow(`
+			SELECT t.name FROM link_table_1 l JOIN test_table_1 t ON t.id = l.test_table_id WHERE l.foo = ?
+		`, foo).Scan(&name)
+		assert.Nil(t, err, "should not return an error")
+		return name
+	}
+
+	t.Run("it inserts rows in batches and still collects their ids", func(t *testing.T) {
+		err := migrate.ApplySeedsWithOptions(db, seeds, migrate.SeedOptions{Driver: "mysql", BatchSize: 100})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(251), getRowCount("test_table_1"), "should insert every row")
+		assert.Equal(t, "user 0", linkedName("first"), "should collect the id of the first row")
+		assert.Equal(t, "user 249", linkedName("249"), "should collect the id of the last repeated row")
+		assert.Equal(t, "last", linkedName("last"), "should collect the id of named rows")
+	})
+}
+
+func TestApplySeedsOnce(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+	defer mustExec("DROP TABLE IF EXISTS seed_history")
+
+	os.Mkdir("../.test", 0777)
+	defer os.RemoveAll("../.test/")
+
+	path := "../.test/20190101001122_once.yaml"
+	options := migrate.SeedOptions{Driver: "mysql", Once: true}
+
+	writeSeed := func(name string) {
+		contents := "seeds:\n  - table: test_table_1\n    insert:\n      - name: " + name + "\n"
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	t.Run("it only applies seed files once", func(t *testing.T) {
+		writeSeed("admin")
+		skipped := make([]bool, 0)
+		options.Progress = func(path string, skip bool) {
+			skipped = append(skipped, skip)
+		}
+
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: path}}, options), "should not return an error")
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: path}}, options), "should not return an error")
+
+		assert.Equal(t, []bool{false, true}, skipped, "should skip the file the second time")
+		assert.Equal(t, int64(1), getRowCount("test_table_1"), "should insert the row once")
+		assert.Equal(t, int64(1), getRowCount("seed_history"), "should record the file")
+	})
+
+	t.Run("it applies seed files again when they change", func(t *testing.T) {
+		writeSeed("root")
+		options.Progress = nil
+
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: path}}, options), "should not return an error")
+		assert.Equal(t, int64(1), getRowCount(`test_table_1 WHERE name = "root"`), "should apply the changed file")
+		assert.Equal(t, int64(1), getRowCount("seed_history"), "should update the record")
+	})
+
+	t.Run("it remembers applied files after tables are truncated and cleared", func(t *testing.T) {
+		skipped := make([]bool, 0)
+		options.Progress = func(path string, skip bool) {
+			skipped = append(skipped, skip)
+		}
+
+		assert.Nil(t, migrate.TruncateTables(db), "should not return an error")
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: path}}, options), "should not return an error")
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: path}}, migrate.SeedOptions{Driver: "mysql", Truncate: true}), "should not return an error")
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: path}}, options), "should not return an error")
+
+		assert.Equal(t, []bool{true, true}, skipped, "should still skip the applied file")
+		assert.Equal(t, int64(1), getRowCount("seed_history"), "should keep the seed history")
+	})
+
+	t.Run("it records files by their path from the root, wherever they are passed from", func(t *testing.T) {
+		mustExec("DELETE FROM seed_history")
+		skipped := make([]bool, 0)
+		options.Root = ".."
+		options.Progress = func(path string, skip bool) {
+			skipped = append(skipped, skip)
+		}
+
+		abs, _ := filepath.Abs(path)
+
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: path}}, options), "should not return an error")
+		assert.Nil(t, migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: abs}}, options), "should not return an error")
+
+		assert.Equal(t, []bool{false, true}, skipped, "should skip the same file passed another way")
+		assert.Equal(t, int64(1), getRowCount(`seed_history WHERE name = ".test/20190101001122_once.yaml"`), "should record the path from the root")
+	})
+}
+
+func TestApplySeedsHelpers(t *testing.T) {
+	mustExec(`CREATE TABLE helper_values (helper VARCHAR(32), value TEXT)`)
+	defer mustExec("DROP TABLE IF EXISTS helper_values")
+
+	os.Setenv("MIGRANT_TEST_HELPER", "from env")
+	defer os.Unsetenv("MIGRANT_TEST_HELPER")
+
+	valueOf := func(helper string) string {
+		var value string
+		err := db.QueryRow("SELECT value FROM helper_values WHERE helper = ?", helper).Scan(&value)
+		assert.Nil(t, err, "should not return an error")
+		return value
+	}
+
+	t.Run("it resolves environment, file, secret, date and hash helpers", func(t *testing.T) {
+		err := migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds8/20190101001122_helpers.yaml"},
+		}, migrate.SeedOptions{
+			Driver: "mysql",
+			Secret: func(uri string) (string, error) {
+				return "hunter2", nil
+			},
+		})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "from env", valueOf("env"), "should read environment variables")
+		assert.Equal(t, "fallback", valueOf("env default"), "should fall back to the default")
+		assert.Equal(t, `{"theme": "dark"}`, valueOf("file"), "should inline files next to the seed file")
+		assert.Equal(t, "hunter2", valueOf("secret"), "should resolve secrets")
+		assert.Equal(t, "2019-01-31 00:00:00", valueOf("dateAdd"), "should add days to dates")
+		assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", valueOf("sha256"), "should hash with sha256")
+		assert.Equal(t, "6e9ef29b75fffc5b7abae527d58fdadb2fe42e7219011976917343065f58ed4a", valueOf("hmac"), "should sign with hmac")
+		assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, valueOf("uuid"), "should generate a uuid")
+		assert.Regexp(t, `^\$argon2id\$v=19\$m=65536,t=1,p=4\$`, valueOf("argon2"), "should hash with argon2id")
+	})
+
+	t.Run("it fails to resolve secrets without a resolver", func(t *testing.T) {
+		mustExec("DELETE FROM helper_values")
+
+		err := migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds8/20190101001122_helpers.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql"})
+
+		assert.NotNil(t, err, "should return an error")
+		assert.Equal(t, int64(0), getRowCount("helper_values"), "should not insert")
+	})
+}
+
+func TestApplySeedsVars(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	seeds := []migrate.SeedFile{
+		{Path: "../fixtures/seeds9/20190102001122_users.yaml"},
+	}
+
+	t.Run("it includes files and resolves shared and templated variables", func(t *testing.T) {
+		err := migrate.ApplySeedsWithOptions(db, seeds, migrate.SeedOptions{Driver: "mysql"})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(1), getRowCount(`test_table_1 WHERE name = "acme"`), "should apply the included file")
+		assert.Equal(t, int64(1), getRowCount(`test_table_1 WHERE name = "admin@acme.example.com"`), "should resolve variables in variables")
+	})
+
+	t.Run("it overrides variables", func(t *testing.T) {
+		mustExec("DELETE FROM test_table_1")
+
+		err := migrate.ApplySeedsWithOptions(db, seeds, migrate.SeedOptions{
+			Driver: "mysql",
+			Vars:   map[string]string{"domain": "example.org"},
+		})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(1), getRowCount(`test_table_1 WHERE name = "admin@example.org"`), "should use the override")
+	})
+}
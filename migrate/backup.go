@@ -0,0 +1,311 @@
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// the number of rows written to each insert statement of a backup
+const backupRowsPerInsert = 100
+
+// BackupTables writes the structure and rows of mysql tables to w as sql statements, which RestoreBackup can
+// read back in. Each table is dropped and created again when the backup is restored.
+func BackupTables(db *sql.DB, w io.Writer, tables []string) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "-- migrant backup of %d tables, %s\n\n", len(tables), time.Now().Format(time.RFC3339))
+	fmt.Fprint(out, "SET FOREIGN_KEY_CHECKS = 0;\n\n")
+
+	for t := range tables {
+		if err := backupTable(db, out, tables[t]); err != nil {
+			return fmt.Errorf("could not back up %s: %s", tables[t], err)
+		}
+	}
+
+	fmt.Fprint(out, "SET FOREIGN_KEY_CHECKS = 1;\n")
+
+	return out.Flush()
+}
+
+// write the statements that recreate a single table
+func backupTable(db *sql.DB, out *bufio.Writer, table string) error {
+	var name, create string
+
+	if err := db.QueryRow("SHOW CREATE TABLE `"+table+"`").Scan(&name, &create); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "DROP TABLE IF EXISTS `%s`;\n%s;\n\n", table, create)
+
+	cols, err := storedColumns(db, table)
+
+	if err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(cols))
+
+	for c := range cols {
+		quoted[c] = "`" + cols[c] + "`"
+	}
+
+	rows, err := db.Query("SELECT " + strings.Join(quoted, ", ") + " FROM `" + table + "`")
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	insert := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES\n", table, strings.Join(quoted, ", "))
+	tuples := make([]string, 0)
+
+	flush := func() {
+		if len(tuples) > 0 {
+			fmt.Fprintf(out, "%s%s;\n", insert, strings.Join(tuples, ",\n"))
+			tuples = tuples[:0]
+		}
+	}
+
+	for rows.Next() {
+		raw := make([]sql.RawBytes, len(cols))
+		dest := make([]interface{}, len(cols))
+
+		for c := range raw {
+			dest[c] = &raw[c]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		literals := make([]string, len(cols))
+
+		for c := range raw {
+			literals[c] = sqlLiteral(raw[c])
+		}
+
+		tuples = append(tuples, "("+strings.Join(literals, ", ")+")")
+
+		if len(tuples) == backupRowsPerInsert {
+			flush()
+		}
+	}
+
+	flush()
+	fmt.Fprint(out, "\n")
+
+	return rows.Err()
+}
+
+// list the columns of a table that rows can be inserted into, in order. Generated columns are left out, since
+// mysql refuses inserts that set them.
+func storedColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+			AND EXTRA NOT LIKE '%VIRTUAL GENERATED%' AND EXTRA NOT LIKE '%STORED GENERATED%'
+		ORDER BY ORDINAL_POSITION
+	`, table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	cols := make([]string, 0)
+
+	for rows.Next() {
+		var col string
+
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+
+		cols = append(cols, col)
+	}
+
+	return cols, rows.Err()
+}
+
+// write a column value as a mysql literal. Text is quoted and escaped, anything else is written in hex.
+func sqlLiteral(raw []byte) string {
+	if raw == nil {
+		return "NULL"
+	}
+
+	if !utf8.Valid(raw) {
+		return "X'" + hex.EncodeToString(raw) + "'"
+	}
+
+	replacer := strings.NewReplacer(
+		`\`, `\\`, `'`, `\'`, "\x00", `\0`, "\n", `\n`, "\r", `\r`, "\x1a", `\Z`,
+	)
+
+	return "'" + replacer.Replace(string(raw)) + "'"
+}
+
+// RestoreBackup runs the statements of a backup that was written by BackupTables. Statements are read and run
+// one at a time on a single connection, so that foreign key checks stay off until the backup is restored.
+func RestoreBackup(db *sql.DB, r io.Reader) error {
+	conn, err := db.Conn(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	statements := statementReader{r: bufio.NewReader(r), line: 1}
+
+	for {
+		q, line, err := statements.next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err == nil {
+			_, err = conn.ExecContext(context.Background(), q)
+		}
+
+		if err != nil {
+			conn.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+}
+
+// statementReader reads sql statements one at a time. Comments are dropped, except for versioned comments like
+// the /*!50100 PARTITION BY ... */ that SHOW CREATE TABLE writes, which mysql runs as part of the statement.
+type statementReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+// read the next statement and the line it starts on, or io.EOF if there are none left
+func (s *statementReader) next() (string, int, error) {
+	var buf strings.Builder
+	start := 0
+
+	for {
+		c, err := s.r.ReadByte()
+
+		if err == io.EOF && strings.TrimSpace(buf.String()) != "" {
+			return strings.TrimSpace(buf.String()), start, nil
+		}
+
+		if err != nil {
+			return "", start, err
+		}
+
+		switch {
+		case c == '\n':
+			s.line++
+			buf.WriteByte(c)
+
+		case c == '#' || (c == '-' && s.peek("-")):
+			if _, err := s.r.ReadString('\n'); err != nil && err != io.EOF {
+				return "", start, err
+			}
+
+			s.line++
+
+		case c == '/' && s.peek("*"):
+			s.r.ReadByte()
+			versioned := s.peek("!")
+			comment, err := s.readUntil("*/")
+
+			if err != nil {
+				return "", start, err
+			}
+
+			if versioned {
+				buf.WriteString("/*" + comment)
+			}
+
+		case c == '\'' || c == '"' || c == '`':
+			if start == 0 {
+				start = s.line
+			}
+
+			buf.WriteByte(c)
+
+			for {
+				q, err := s.r.ReadByte()
+
+				if err != nil {
+					return "", start, err
+				}
+
+				buf.WriteByte(q)
+
+				if q == '\n' {
+					s.line++
+				}
+
+				if q == '\\' {
+					escaped, err := s.r.ReadByte()
+
+					if err != nil {
+						return "", start, err
+					}
+
+					buf.WriteByte(escaped)
+				} else if q == c {
+					break
+				}
+			}
+
+		case c == ';':
+			if q := strings.TrimSpace(buf.String()); q != "" {
+				return q, start, nil
+			}
+
+			buf.Reset()
+			start = 0
+
+		default:
+			if start == 0 && c != ' ' && c != '\t' && c != '\r' {
+				start = s.line
+			}
+
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// returns true if the next bytes are the prefix, without reading them
+func (s *statementReader) peek(prefix string) bool {
+	next, _ := s.r.Peek(len(prefix))
+	return string(next) == prefix
+}
+
+// read up to and including the end, counting lines on the way
+func (s *statementReader) readUntil(end string) (string, error) {
+	var text strings.Builder
+
+	for !strings.HasSuffix(text.String(), end) {
+		c, err := s.r.ReadByte()
+
+		if err != nil {
+			return "", err
+		}
+
+		if c == '\n' {
+			s.line++
+		}
+
+		text.WriteByte(c)
+	}
+
+	return text.String(), nil
+}
@@ -0,0 +1,80 @@
+package migrate_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupTables(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	mustExec(
+		`INSERT INTO test_table_1 (id, name) VALUES (1, 'it''s a "test" \\ with
+a new line'), (2, NULL)`,
+		`INSERT INTO link_table_1 (test_table_id, foo) VALUES (1, "bar")`,
+	)
+
+	t.Run("it backs up and restores tables", func(t *testing.T) {
+		var backup bytes.Buffer
+
+		err := migrate.BackupTables(db, &backup, []string{"test_table_1", "link_table_1"})
+		assert.Nil(t, err, "should not return an error")
+		assert.Contains(t, backup.String(), "DROP TABLE IF EXISTS `test_table_1`", "should recreate tables")
+
+		mustExec("DROP TABLE link_table_1", "DELETE FROM test_table_1")
+
+		err = migrate.RestoreBackup(db, &backup)
+		assert.Nil(t, err, "should not return an error")
+
+		var name string
+		err = db.QueryRow("SELECT name FROM test_table_1 WHERE id = 1").Scan(&name)
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "it's a \"test\" \\ with\na new line", name, "should restore text as it was")
+		assert.Equal(t, int64(1), getRowCount("test_table_1 WHERE name IS NULL"), "should restore nulls")
+		assert.Equal(t, int64(1), getRowCount("link_table_1"), "should restore dropped tables")
+	})
+
+	t.Run("it leaves views out of the tables to back up", func(t *testing.T) {
+		mustExec("CREATE VIEW test_view_1 AS SELECT id, name FROM test_table_1")
+		defer mustExec("DROP VIEW IF EXISTS test_view_1")
+
+		tables, err := migrate.ListTables(db)
+		assert.Nil(t, err, "should not return an error")
+		assert.Contains(t, tables, "test_table_1", "should list tables")
+		assert.NotContains(t, tables, "test_view_1", "should not list views")
+
+		var backup bytes.Buffer
+		err = migrate.BackupTables(db, &backup, tables)
+		assert.Nil(t, err, "should back up a schema with a view")
+	})
+
+	t.Run("it restores partitions and generated columns", func(t *testing.T) {
+		mustExec(`
+			CREATE TABLE test_parts (
+				id INT,
+				amount INT,
+				doubled INT AS (amount * 2),
+				PRIMARY KEY (id)
+			) PARTITION BY HASH (id) PARTITIONS 2
+		`, "INSERT INTO test_parts (id, amount) VALUES (1, 5)")
+
+		defer mustExec("DROP TABLE IF EXISTS test_parts")
+
+		var backup bytes.Buffer
+
+		err := migrate.BackupTables(db, &backup, []string{"test_parts"})
+		assert.Nil(t, err, "should not return an error")
+		assert.NotContains(t, backup.String(), "`doubled`) VALUES", "should not insert generated columns")
+
+		mustExec("DROP TABLE test_parts")
+
+		err = migrate.RestoreBackup(db, &backup)
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(1), getRowCount("test_parts WHERE doubled = 10"), "should restore rows")
+		assert.Equal(t, int64(2), getRowCount(`information_schema.PARTITIONS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = "test_parts" AND PARTITION_NAME IS NOT NULL`), "should restore partitions")
+	})
+}
@@ -7,7 +7,7 @@ import (
 	"log"
 	"os"
 	"path"
-	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -24,10 +24,16 @@ type MigrationFile struct {
 	Applied bool
 }
 
-// CheckMigrations returns a list of migrations in the specified folder, indicating which
+// CheckMigrations returns a list of timestamped migrations in the specified folder, indicating which
 // ones have already been applied to the database.
 func CheckMigrations(db *sql.DB, migrationPath string) []MigrationFile {
-	list := getList(migrationPath)
+	return CheckVersionedMigrations(db, migrationPath, VersioningTimestamp)
+}
+
+// CheckVersionedMigrations returns a list of migrations named with the given versioning scheme in the
+// specified folder, indicating which ones have already been applied to the database.
+func CheckVersionedMigrations(db *sql.DB, migrationPath, versioning string) []MigrationFile {
+	list := ListMigrationFiles(migrationPath, versioning)
 	migrations := getMigrations(db)
 
 	// check to see if migrations are applied
@@ -73,8 +79,9 @@ func getMigrations(db *sql.DB) []Migration {
 	return migrations
 }
 
-// get the files in a directory
-func getList(source string) []MigrationFile {
+// ListMigrationFiles returns the migration files in a directory named with the given versioning scheme,
+// ordered by version.
+func ListMigrationFiles(source, versioning string) []MigrationFile {
 	info, err := os.Stat(source)
 
 	if err != nil {
@@ -91,27 +98,31 @@ func getList(source string) []MigrationFile {
 		log.Fatal(err)
 	}
 
-	checker := regexp.MustCompile(`^\d{14}_.*\.sql$`)
-	splitter := regexp.MustCompile(`^(\d*)_(.*)\.sql$`)
+	splitter, ok := versionPatterns[normalizeVersioning(versioning)]
+
+	if !ok {
+		log.Fatal("unknown versioning scheme: " + versioning)
+	}
+
 	list := make([]MigrationFile, 0)
 
 	for file := range dir {
-		if !checker.MatchString(dir[file].Name()) {
-			continue
-		}
-
 		matches := splitter.FindStringSubmatch(dir[file].Name())
 
 		if len(matches) < 3 {
-			log.Fatal("not a valid migration file")
+			continue
 		}
 
 		mf := MigrationFile{}
 		mf.Path = path.Join(source, dir[file].Name())      // migration location
-		mf.Prefix = matches[1]                             // the timestamp id thing on the front
+		mf.Prefix = matches[1]                             // the version id thing on the front
 		mf.Desc = strings.ReplaceAll(matches[2], "_", " ") // a more or less readable description
 		list = append(list, mf)
 	}
 
+	sort.SliceStable(list, func(i, j int) bool {
+		return compareVersions(versioning, list[i].Prefix, list[j].Prefix) < 0
+	})
+
 	return list
 }
@@ -0,0 +1,273 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BatchOptions controls how data migrations are run. Settings in a migration's directive take precedence.
+type BatchOptions struct {
+	Size     int64                        // keys covered by each batch
+	Pause    time.Duration                // pause between batches
+	Progress func(progress BatchProgress) // called after each batch
+}
+
+// BatchProgress reports how far along a data migration is.
+type BatchProgress struct {
+	Name       string
+	LastKey    int64
+	MaxKey     int64
+	Rows       int64
+	RowsPerSec float64
+}
+
+// DefaultBatchOptions returns the options used when none are configured.
+func DefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		Size: 1000,
+	}
+}
+
+// a data migration looks like:
+//
+//	-- migrant:batch table=orders key=id size=500 pause=100ms
+//	UPDATE orders SET x = y WHERE id >= :start AND id < :end;
+var (
+	batchDirective   = regexp.MustCompile(`migrant:batch\b([^\n]*)`)
+	batchPlaceholder = regexp.MustCompile(`^:(start|end)\b`)
+)
+
+// HasBatchDirective returns true if the sql is a data migration.
+func HasBatchDirective(contents string) bool {
+	return batchDirective.MatchString(contents)
+}
+
+// CheckpointTable records how far each data migration got, so that it can resume where it stopped.
+const CheckpointTable = "migration_checkpoints"
+
+// InitCheckpointTable creates the table that data migrations record their progress in, if it does not exist.
+func InitCheckpointTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS `+CheckpointTable+`(
+			name VARCHAR(%d) NOT NULL,
+			last_key BIGINT NOT NULL,
+			row_count BIGINT NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW() ON UPDATE NOW(),
+			PRIMARY KEY (name)
+		);
+	`, MigrationNameLength))
+
+	return err
+}
+
+// RunDataMigration runs the statement of a data migration once for each range of keys in the target table,
+// binding :start (inclusive) and :end (exclusive) to the bounds of the range. Progress is checkpointed along
+// with each batch, so an interrupted run resumes where it left off. The checkpoint is removed once it is done.
+func RunDataMigration(db *sql.DB, name, contents string, options BatchOptions) error {
+	statements := splitStatements(contents)
+
+	if len(statements) != 1 {
+		return fmt.Errorf("data migration %s must contain exactly one statement", name)
+	}
+
+	directive := batchDirective.FindStringSubmatch(statements[0].comments)
+
+	if directive == nil {
+		return fmt.Errorf("data migration %s is missing a migrant:batch directive", name)
+	}
+
+	params := make(map[string]string)
+
+	for _, field := range strings.Fields(directive[1]) {
+		pair := strings.SplitN(field, "=", 2)
+
+		if len(pair) != 2 {
+			return fmt.Errorf("bad migrant:batch setting in %s: %s", name, field)
+		}
+
+		params[pair[0]] = pair[1]
+	}
+
+	table := params["table"]
+	key := params["key"]
+
+	if table == "" {
+		return fmt.Errorf("data migration %s must set a table in its migrant:batch directive", name)
+	}
+
+	if key == "" {
+		key = "id"
+	}
+
+	if size, ok := params["size"]; ok {
+		n, err := strconv.ParseInt(size, 10, 64)
+
+		if err != nil || n <= 0 {
+			return fmt.Errorf("bad batch size in %s: %s", name, size)
+		}
+
+		options.Size = n
+	}
+
+	if pause, ok := params["pause"]; ok {
+		d, err := time.ParseDuration(pause)
+
+		if err != nil {
+			return fmt.Errorf("bad batch pause in %s: %s", name, pause)
+		}
+
+		options.Pause = d
+	}
+
+	if options.Size <= 0 {
+		options.Size = DefaultBatchOptions().Size
+	}
+
+	query, bounds := bindBatchPlaceholders(statements[0].sql)
+
+	if len(bounds) == 0 {
+		return fmt.Errorf("data migration %s must use :start and :end to limit each batch", name)
+	}
+
+	if err := InitCheckpointTable(db); err != nil {
+		return err
+	}
+
+	var min, max sql.NullInt64
+	err := db.QueryRow(fmt.Sprintf("SELECT MIN(`%s`), MAX(`%s`) FROM `%s`", key, key, table)).Scan(&min, &max)
+
+	if err != nil {
+		return err
+	}
+
+	progress := BatchProgress{Name: name, MaxKey: max.Int64}
+	start := min.Int64
+
+	// resume from the last checkpoint if there is one
+	err = db.QueryRow("SELECT last_key, row_count FROM "+CheckpointTable+" WHERE name = ?", name).Scan(&progress.LastKey, &progress.Rows)
+
+	switch {
+	case err == nil:
+		start = progress.LastKey
+	case err != sql.ErrNoRows:
+		return err
+	}
+
+	began := time.Now()
+	resumedRows := progress.Rows
+
+	for ; min.Valid && start <= max.Int64; start += options.Size {
+		end := start + options.Size
+		args := make([]interface{}, len(bounds))
+
+		for b := range bounds {
+			if bounds[b] == "start" {
+				args[b] = start
+			} else {
+				args[b] = end
+			}
+		}
+
+		affected, err := runBatch(db, name, query, args, end, progress.Rows)
+
+		if err != nil {
+			return err
+		}
+
+		progress.Rows += affected
+		progress.LastKey = end
+
+		if elapsed := time.Since(began).Seconds(); elapsed > 0 {
+			progress.RowsPerSec = float64(progress.Rows-resumedRows) / elapsed
+		}
+
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+
+		time.Sleep(options.Pause)
+	}
+
+	_, err = db.Exec("DELETE FROM "+CheckpointTable+" WHERE name = ?", name)
+	return err
+}
+
+// run a single batch and checkpoint it in one transaction, so that a batch is never run twice. Returns the
+// number of rows the batch changed.
+func runBatch(db *sql.DB, name, query string, args []interface{}, end, rows int64) (int64, error) {
+	tx, err := db.Begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(query, args...)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	affected, _ := res.RowsAffected()
+
+	_, err = tx.Exec(`
+		INSERT INTO `+CheckpointTable+` (name, last_key, row_count) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE last_key = VALUES(last_key), row_count = VALUES(row_count)
+	`, name, end, rows+affected)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return affected, tx.Commit()
+}
+
+// swap the :start and :end placeholders of a data migration for positional ones, and return which bound each
+// of them stands for. Placeholders inside of quoted strings and names are left alone.
+func bindBatchPlaceholders(sql string) (string, []string) {
+	var query strings.Builder
+	bounds := make([]string, 0)
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+
+			for end < len(sql) && sql[end] != c {
+				if sql[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end >= len(sql) {
+				end = len(sql) - 1
+			}
+
+			query.WriteString(sql[i : end+1])
+			i = end
+
+		case c == ':':
+			if m := batchPlaceholder.FindStringSubmatch(sql[i:]); m != nil {
+				bounds = append(bounds, m[1])
+				query.WriteByte('?')
+				i += len(m[0]) - 1
+				continue
+			}
+
+			query.WriteByte(c)
+
+		default:
+			query.WriteByte(c)
+		}
+	}
+
+	return query.String(), bounds
+}
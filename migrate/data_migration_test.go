@@ -0,0 +1,76 @@
+package migrate_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDataMigration(t *testing.T) {
+	contents, err := ioutil.ReadFile("../fixtures/migrations_data/20190101001122_backfill_names.sql")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustExec(`
+		CREATE TABLE test_table_2 (
+			id INT AUTO_INCREMENT,
+			name VARCHAR(32),
+			PRIMARY KEY (id)
+		);
+	`, `INSERT INTO test_table_2 (name) VALUES (NULL), (NULL), (NULL), (NULL), (NULL);`)
+
+	defer mustExec("DROP TABLE IF EXISTS test_table_2", "DROP TABLE IF EXISTS migration_checkpoints")
+
+	t.Run("it runs the migration in batches", func(t *testing.T) {
+		batches := make([]migrate.BatchProgress, 0)
+		options := migrate.DefaultBatchOptions()
+		options.Progress = func(p migrate.BatchProgress) { batches = append(batches, p) }
+
+		err := migrate.RunDataMigration(db, "20190101001122", string(contents), options)
+		assert.Nil(t, err, "should not return an error")
+		assert.Len(t, batches, 3, "should use the batch size from the directive")
+		assert.Equal(t, int64(5), batches[2].Rows, "should report rows affected")
+
+		var name string
+		db.QueryRow("SELECT name FROM test_table_2 WHERE id = 5").Scan(&name)
+		assert.Equal(t, "name 5", name, "should update every row")
+
+		assert.Equal(t, int64(0), getRowCount("migration_checkpoints"), "should remove the checkpoint when done")
+	})
+
+	t.Run("it resumes from a checkpoint", func(t *testing.T) {
+		mustExec(
+			"UPDATE test_table_2 SET name = NULL",
+			"INSERT INTO migration_checkpoints (name, last_key, row_count) VALUES ('20190101001122', 5, 4)",
+		)
+
+		batches := make([]migrate.BatchProgress, 0)
+		options := migrate.DefaultBatchOptions()
+		options.Progress = func(p migrate.BatchProgress) { batches = append(batches, p) }
+
+		err := migrate.RunDataMigration(db, "20190101001122", string(contents), options)
+		assert.Nil(t, err, "should not return an error")
+		assert.Len(t, batches, 1, "should only run the remaining batch")
+
+		var name string
+		db.QueryRow("SELECT name FROM test_table_2 WHERE id = 1").Scan(&name)
+		assert.Equal(t, "", name, "should not update rows before the checkpoint")
+	})
+
+	t.Run("it leaves placeholders in quoted text alone", func(t *testing.T) {
+		contents := "-- migrant:batch table=test_table_2 size=10\nUPDATE test_table_2 SET name = ':start to :end' WHERE id >= :start AND id < :end;"
+
+		err := migrate.RunDataMigration(db, "20190102001122", contents, migrate.DefaultBatchOptions())
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(5), getRowCount(`test_table_2 WHERE name = ":start to :end"`), "should keep quoted text")
+	})
+
+	t.Run("it needs a table", func(t *testing.T) {
+		err := migrate.RunDataMigration(db, "bogus", "-- migrant:batch size=10\nUPDATE foo SET x = 1 WHERE id >= :start AND id < :end;", migrate.DefaultBatchOptions())
+		assert.NotNil(t, err, "should return an error")
+	})
+}
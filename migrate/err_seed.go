@@ -0,0 +1,35 @@
+package migrate
+
+import "fmt"
+
+// ErrSeed describes where a seed run failed.
+type ErrSeed struct {
+	path   string
+	seed   int
+	column string
+	err    error
+}
+
+func (e *ErrSeed) Error() string {
+	location := e.path
+
+	if e.seed >= 0 {
+		location += fmt.Sprintf(", seed %d", e.seed)
+	}
+
+	if e.column != "" {
+		location += ", column " + e.column
+	}
+
+	return fmt.Sprintf("error seeding %s: %s", location, e.err)
+}
+
+// NewErrSeed returns new error. Pass a seed index of -1 and an empty column if they are not known.
+func NewErrSeed(path string, seed int, column string, err error) *ErrSeed {
+	return &ErrSeed{
+		path:   path,
+		seed:   seed,
+		column: column,
+		err:    err,
+	}
+}
@@ -0,0 +1,21 @@
+package migrate
+
+import "database/sql"
+
+// execer is implemented by both databases and transactions
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// exec each statement in order, stopping at the first error
+func execAll(db execer, statements ...string) error {
+	for s := range statements {
+		if _, err := db.Exec(statements[s]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
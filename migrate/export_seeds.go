@@ -0,0 +1,244 @@
+package migrate
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// ExportOptions selects the rows that are exported to a seed file.
+type ExportOptions struct {
+	Tables []string          // the tables to export
+	Where  map[string]string // a condition for the rows of each table, the condition for "" applies to every table too
+}
+
+// a foreign key column and the column it references
+type foreignKeyColumn struct {
+	Table     string
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+// ExportSeeds reads rows from a mysql database and turns them into seed input that ApplySeeds can consume.
+// Tables are ordered so that referenced tables come first. Auto increment columns are left out, and foreign
+// keys that point at an exported row are replaced with an id template, so the seed file keeps its references
+// when it is applied to a database that hands out different ids.
+func ExportSeeds(db *sql.DB, options ExportOptions) (SeedInput, error) {
+	input := SeedInput{Seeds: make([]Seed, 0)}
+	fks, err := foreignKeys(db, options.Tables)
+
+	if err != nil {
+		return input, err
+	}
+
+	exported := make(map[string]map[string]int) // the index of each exported row by its auto increment id
+	autoIncrement := make(map[string]string)
+
+	for _, table := range orderTables(options.Tables, fks) {
+		pk, err := autoIncrementColumn(db, table)
+
+		if err != nil {
+			return input, err
+		}
+
+		autoIncrement[table] = pk
+		exported[table] = make(map[string]int)
+		seed := Seed{Table: table, Insert: make([]SeedRow, 0)}
+
+		q := "SELECT * FROM `" + table + "`"
+
+		if where := whereFor(options.Where, table); where != "" {
+			q += " WHERE " + where
+		}
+
+		if pk != "" {
+			q += " ORDER BY `" + pk + "`"
+		}
+
+		rows, err := db.Query(q)
+
+		if err != nil {
+			return input, fmt.Errorf("could not export %s: %s", table, err)
+		}
+
+		cols, err := rows.Columns()
+
+		if err != nil {
+			rows.Close()
+			return input, err
+		}
+
+		for rows.Next() {
+			raw := make([]sql.RawBytes, len(cols))
+			dest := make([]interface{}, len(cols))
+
+			for c := range raw {
+				dest[c] = &raw[c]
+			}
+
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return input, err
+			}
+
+			index := len(seed.Insert)
+			row := make(SeedRow)
+
+			for c, col := range cols {
+				switch {
+				case col == pk:
+					exported[table][string(raw[c])] = index
+
+				case raw[c] == nil:
+					row[col] = nil
+
+				default:
+					row[col] = exportValue(raw[c])
+
+					if ref, ok := idReference(fks, exported, autoIncrement, table, col, string(raw[c]), index); ok {
+						row[col] = ref
+					}
+				}
+			}
+
+			seed.Insert = append(seed.Insert, row)
+		}
+
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return input, err
+		}
+
+		input.Seeds = append(input.Seeds, seed)
+	}
+
+	return input, nil
+}
+
+// WriteSeedFile writes seed input to a yaml file.
+func WriteSeedFile(path string, input SeedInput) error {
+	out, err := yaml.Marshal(input)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// MarshalYAML writes the value with its !base64 tag.
+func (v Base64Value) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!base64", Value: string(v)}, nil
+}
+
+// find the foreign keys between the exported tables
+func foreignKeys(db *sql.DB, tables []string) ([]foreignKeyColumn, error) {
+	rows, err := db.Query(`
+		SELECT TABLE_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+		FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME IS NOT NULL
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	fks := make([]foreignKeyColumn, 0)
+
+	for rows.Next() {
+		fk := foreignKeyColumn{}
+
+		if err := rows.Scan(&fk.Table, &fk.Column, &fk.RefTable, &fk.RefColumn); err != nil {
+			return nil, err
+		}
+
+		if contains(tables, fk.Table) && contains(tables, fk.RefTable) {
+			fks = append(fks, fk)
+		}
+	}
+
+	return fks, rows.Err()
+}
+
+// order tables so that tables come after the tables they reference, keeping the given order where possible.
+// Tables that reference each other are left in the given order.
+func orderTables(tables []string, fks []foreignKeyColumn) []string {
+	ordered := make([]string, 0)
+	remaining := append([]string{}, tables...)
+
+	for len(remaining) > 0 {
+		next := 0
+
+	search:
+		for r := range remaining {
+			for f := range fks {
+				if fks[f].Table == remaining[r] && fks[f].RefTable != remaining[r] && contains(remaining, fks[f].RefTable) {
+					continue search
+				}
+			}
+
+			next = r
+			break
+		}
+
+		ordered = append(ordered, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+
+	return ordered
+}
+
+// the condition for a table's rows, which must match both its own condition and the one for every table
+func whereFor(where map[string]string, table string) string {
+	cond, all := where[table], where[""]
+
+	switch {
+	case cond == "":
+		return all
+	case all == "":
+		return cond
+	}
+
+	return "(" + all + ") AND (" + cond + ")"
+}
+
+// turn a column value into a seed value. Text is escaped so that it is not run as a template, and anything
+// else is exported as base64.
+func exportValue(raw []byte) interface{} {
+	if !utf8.Valid(raw) {
+		return Base64Value(base64.StdEncoding.EncodeToString(raw))
+	}
+
+	return strings.Replace(string(raw), "{{", `{{ "{{" }}`, -1)
+}
+
+// build an id template for a foreign key column if it references the auto increment id of a row that was
+// exported before it
+func idReference(fks []foreignKeyColumn, exported map[string]map[string]int, autoIncrement map[string]string, table, col, val string, index int) (string, bool) {
+	for f := range fks {
+		fk := fks[f]
+
+		if fk.Table != table || fk.Column != col || fk.RefColumn != autoIncrement[fk.RefTable] {
+			continue
+		}
+
+		refIndex, ok := exported[fk.RefTable][val]
+
+		if !ok || (fk.RefTable == table && refIndex >= index) {
+			continue
+		}
+
+		return fmt.Sprintf(`{{ id "%s" %d }}`, fk.RefTable, refIndex), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,100 @@
+package migrate_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSeedFile(t *testing.T) {
+	t.Run("it writes seed files that can be read back", func(t *testing.T) {
+		os.Mkdir("../.test", 0777)
+
+		defer func() {
+			os.RemoveAll("../.test/")
+		}()
+
+		input := migrate.SeedInput{
+			Seeds: []migrate.Seed{
+				{
+					Table: "users",
+					Insert: []migrate.SeedRow{
+						{"name": "bob", "avatar": migrate.Base64Value("AAEC"), "deleted_at": nil},
+					},
+				},
+			},
+		}
+
+		err := migrate.WriteSeedFile("../.test/users.yaml", input)
+		assert.Nil(t, err, "should not return an error")
+
+		read, err := migrate.ReadSeedFile("../.test/users.yaml")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, input, read, "should read back the same seeds")
+	})
+}
+
+func TestExportSeeds(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	mustExec(
+		`INSERT INTO test_table_1 (id, name) VALUES (10, "alice"), (20, "{{ bob }}"), (30, "carol")`,
+		`INSERT INTO link_table_1 (test_table_id, foo) VALUES (20, "bar")`,
+	)
+
+	t.Run("it exports rows and replaces foreign keys with id templates", func(t *testing.T) {
+		input, err := migrate.ExportSeeds(db, migrate.ExportOptions{
+			Tables: []string{"link_table_1", "test_table_1"},
+			Where:  map[string]string{"test_table_1": "id < 30"},
+		})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Len(t, input.Seeds, 2, "should export both tables")
+		assert.Equal(t, "test_table_1", input.Seeds[0].Table, "should export referenced tables first")
+		assert.Equal(t, []migrate.SeedRow{
+			{"name": "alice"},
+			{"name": `{{ "{{" }} bob }}`},
+		}, input.Seeds[0].Insert, "should leave out ids and escape templates")
+		assert.Equal(t, []migrate.SeedRow{
+			{"test_table_id": `{{ id "test_table_1" 1 }}`, "foo": "bar"},
+		}, input.Seeds[1].Insert, "should reference the exported row")
+	})
+
+	t.Run("it applies conditions for every table along with the table's own", func(t *testing.T) {
+		input, err := migrate.ExportSeeds(db, migrate.ExportOptions{
+			Tables: []string{"test_table_1"},
+			Where:  map[string]string{"": `name <> "carol"`, "test_table_1": "id > 10"},
+		})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, []migrate.SeedRow{
+			{"name": `{{ "{{" }} bob }}`},
+		}, input.Seeds[0].Insert, "should only export rows that match both conditions")
+	})
+
+	t.Run("it exports seeds that can be applied", func(t *testing.T) {
+		os.Mkdir("../.test", 0777)
+
+		defer func() {
+			os.RemoveAll("../.test/")
+		}()
+
+		input, err := migrate.ExportSeeds(db, migrate.ExportOptions{Tables: []string{"test_table_1", "link_table_1"}})
+		assert.Nil(t, err, "should not return an error")
+		assert.Nil(t, migrate.WriteSeedFile("../.test/export.yaml", input), "should write the seed file")
+
+		mustExec("DELETE FROM link_table_1", "DELETE FROM test_table_1")
+		err = migrate.ApplySeedsWithOptions(db, []migrate.SeedFile{{Path: "../.test/export.yaml"}}, migrate.SeedOptions{Driver: "mysql"})
+		assert.Nil(t, err, "should not return an error")
+
+		var name string
+		err = db.QueryRow(`
+			SELECT t.name FROM link_table_1 l JOIN test_table_1 t ON t.id = l.test_table_id
+		`).Scan(&name)
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "{{ bob }}", name, "should keep references and text")
+	})
+}
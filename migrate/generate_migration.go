@@ -1,18 +1,59 @@
 package migrate
 
 import (
+	"bytes"
 	"io/ioutil"
 	"path"
 	"strings"
+	"text/template"
 	"time"
 )
 
+// DefaultMigrationTemplate is used to write new migrations when no template has been configured.
+const DefaultMigrationTemplate = `{{ if .SQL }}{{ .SQL }}{{ else }}-- Write your migration here{{ end }}`
+
+// MigrationTemplateData holds the values that are available inside of a migration template.
+type MigrationTemplateData struct {
+	Desc      string
+	Version   string
+	Timestamp string
+	Database  string
+	Author    string
+	SQL       string
+}
+
 // GenerateMigration creates a new empty sql file prefixed with a time stamp.
 func GenerateMigration(dir, desc string) error {
-	dateComponent := time.Now().Format("20060102150405")
-	descComponent := strings.ReplaceAll(desc, " ", "_")
-	fileName := dateComponent + "_" + descComponent + ".sql"
+	return GenerateMigrationFromTemplate(dir, DefaultMigrationTemplate, MigrationTemplateData{Desc: desc})
+}
+
+// GenerateMigrationFromTemplate creates a new sql file prefixed with the version of the data, using the given
+// go template for its contents. If no version is set, the file is prefixed with a local time stamp.
+func GenerateMigrationFromTemplate(dir, tmpl string, data MigrationTemplateData) error {
+	t, err := template.New("migration").Parse(tmpl)
+
+	if err != nil {
+		return err
+	}
+
+	if data.Timestamp == "" {
+		data.Timestamp = time.Now().Format("20060102150405")
+	}
+
+	if data.Version == "" {
+		data.Version = data.Timestamp
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+
+	if err != nil {
+		return err
+	}
+
+	descComponent := strings.ReplaceAll(data.Desc, " ", "_")
+	fileName := data.Version + "_" + descComponent + ".sql"
 	filePath := path.Join(dir, fileName)
-	err := ioutil.WriteFile(filePath, []byte("-- Write your migration here"), 0644)
+	err = ioutil.WriteFile(filePath, buf.Bytes(), 0644)
 	return err
 }
@@ -30,4 +30,34 @@ func TestGenerateMigration(t *testing.T) {
 		assert.Regexp(t, regexp.MustCompile(`^\d{14}_.*\.sql$`), dirInfo[0].Name(), "name of file should match pattern")
 		assert.NotContains(t, dirInfo[0].Name(), " ", "should not contain any white space")
 	})
+
+	t.Run("it generates a migration from a template", func(t *testing.T) {
+		os.Mkdir("../.test", 0777)
+
+		defer func() {
+			os.RemoveAll("../.test/")
+		}()
+
+		data := migrate.MigrationTemplateData{
+			Desc:     "foo bar baz",
+			Database: "hamburgers",
+			Author:   "bob",
+		}
+
+		err := migrate.GenerateMigrationFromTemplate("../.test", "-- {{ .Desc }} {{ .Database }} {{ .Author }} {{ .Timestamp }}", data)
+		assert.Nil(t, err, "should return no errors")
+
+		dirInfo, err := ioutil.ReadDir("../.test")
+		assert.Nil(t, err, "should be able to read test dir")
+		assert.Len(t, dirInfo, 1, "should only have 1 generated file")
+
+		contents, err := ioutil.ReadFile("../.test/" + dirInfo[0].Name())
+		assert.Nil(t, err, "should be able to read generated file")
+		assert.Equal(t, "-- foo bar baz hamburgers bob "+dirInfo[0].Name()[:14], string(contents), "should render template")
+	})
+
+	t.Run("it returns an error for a bad template", func(t *testing.T) {
+		err := migrate.GenerateMigrationFromTemplate("../.test", "{{ .Nope", migrate.MigrationTemplateData{Desc: "foo"})
+		assert.NotNil(t, err, "should return an error")
+	})
 }
@@ -2,6 +2,7 @@ package migrate
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 )
 
@@ -13,14 +14,21 @@ func InitMigrationTable(db *sql.DB) {
 		return
 	}
 
-	_, err = db.Exec(`
+	_, err = db.Exec(fmt.Sprintf(`
 		CREATE TABLE migrations(
-			name VARCHAR(14) NOT NULL,
+			name VARCHAR(%d) NOT NULL,
 			created_at TIMESTAMP NOT NULL DEFAULT NOW()
 		);
-	`)
+	`, MigrationNameLength))
 
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// WidenMigrationTable widens the name column of migration tables that were created for timestamps only, so
+// that they can hold any kind of version.
+func WidenMigrationTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE migrations MODIFY name VARCHAR(%d) NOT NULL", MigrationNameLength))
+	return err
+}
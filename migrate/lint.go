@@ -0,0 +1,364 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Lint rules that can be disabled in config or suppressed with an inline comment.
+const (
+	LintDropColumn            = "drop_column"
+	LintRename                = "rename"
+	LintAlterLock             = "alter_lock"
+	LintNotNullWithoutDefault = "not_null_without_default"
+	LintForeignKeyIndex       = "foreign_key_without_index"
+	LintImplicitCommit        = "implicit_commit"
+)
+
+// LintRules lists every rule the linter knows about.
+var LintRules = []string{
+	LintDropColumn,
+	LintRename,
+	LintAlterLock,
+	LintNotNullWithoutDefault,
+	LintForeignKeyIndex,
+	LintImplicitCommit,
+}
+
+// LintWarning describes a potentially dangerous statement in a migration.
+type LintWarning struct {
+	Path    string
+	Line    int
+	Rule    string
+	Message string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s:%d [%s] %s", w.Path, w.Line, w.Rule, w.Message)
+}
+
+// statement is a single sql statement along with the comments that preceded it
+type statement struct {
+	sql        string
+	comments   string
+	line       int
+	start, end int // where the statement is in the source, from the end of the one before it to its semicolon
+}
+
+var (
+	lintIgnore     = regexp.MustCompile(`migrant:ignore\b[ \t]*([\w, \t]*)`)
+	whitespace     = regexp.MustCompile(`\s+`)
+	alterTable     = regexp.MustCompile(`^ALTER\s+(ONLINE\s+|IGNORE\s+)?TABLE\b`)
+	createTable    = regexp.MustCompile(`^CREATE\s+(TEMPORARY\s+)?TABLE\b`)
+	dropColumn     = regexp.MustCompile(`^DROP\s+(COLUMN\s+)?(\S+)`)
+	renameClause   = regexp.MustCompile(`^(RENAME\b|CHANGE\b)`)
+	algorithmNone  = regexp.MustCompile(`\bALGORITHM\s*=\s*INPLACE\b`)
+	lockNone       = regexp.MustCompile(`\bLOCK\s*=\s*NONE\b`)
+	addColumn      = regexp.MustCompile(`^ADD\s+(COLUMN\s+)?(\S+)`)
+	foreignKey     = regexp.MustCompile(`\bFOREIGN\s+KEY\s*(\S*\s*)?\(([^)]*)\)`)
+	indexClause    = regexp.MustCompile(`^(ADD\s+)?(CONSTRAINT\s+\S+\s+)?(PRIMARY\s+KEY|UNIQUE(\s+(INDEX|KEY))?|INDEX|KEY)\b[^(]*\(([^)]*)\)`)
+	beginStatement = regexp.MustCompile(`^(BEGIN|START\s+TRANSACTION)\b`)
+	endStatement   = regexp.MustCompile(`^(COMMIT|ROLLBACK)\b`)
+	ddlStatement   = regexp.MustCompile(`^(CREATE|ALTER|DROP|RENAME|TRUNCATE)\b`)
+)
+
+// words that can follow ADD or DROP without it being a column
+var notColumns = map[string]bool{
+	"INDEX": true, "KEY": true, "PRIMARY": true, "UNIQUE": true, "FOREIGN": true,
+	"CONSTRAINT": true, "CHECK": true, "FULLTEXT": true, "SPATIAL": true, "PARTITION": true,
+}
+
+// LintMigration reads a migration file and returns warnings for any dangerous or non-portable statements
+// it contains. Rules in the disabled list are skipped.
+func LintMigration(path string, disabled []string) ([]LintWarning, error) {
+	contents, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := LintSQL(string(contents), disabled)
+
+	for w := range warnings {
+		warnings[w].Path = path
+	}
+
+	return warnings, nil
+}
+
+// LintSQL returns warnings for any dangerous or non-portable statements in the passed sql. Rules in the
+// disabled list are skipped, as are rules suppressed by a `-- migrant:ignore rule` comment before a statement.
+// A `-- migrant:ignore` comment with no rules suppresses every rule for that statement.
+func LintSQL(sql string, disabled []string) []LintWarning {
+	warnings := make([]LintWarning, 0)
+	inTransaction := false
+
+	for _, s := range splitStatements(sql) {
+		ignored := make(map[string]bool)
+
+		for d := range disabled {
+			ignored[disabled[d]] = true
+		}
+
+		for _, match := range lintIgnore.FindAllStringSubmatch(s.comments, -1) {
+			rules := strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+
+			if len(rules) == 0 {
+				rules = LintRules
+			}
+
+			for r := range rules {
+				ignored[rules[r]] = true
+			}
+		}
+
+		// online statements are applied without locking
+		if onlineMarker.MatchString(s.comments) {
+			ignored[LintAlterLock] = true
+		}
+
+		warn := func(rule, message string) {
+			if !ignored[rule] {
+				warnings = append(warnings, LintWarning{Line: s.line, Rule: rule, Message: message})
+			}
+		}
+
+		upper := strings.ToUpper(whitespace.ReplaceAllString(s.sql, " "))
+
+		switch {
+		case beginStatement.MatchString(upper):
+			inTransaction = true
+		case endStatement.MatchString(upper):
+			inTransaction = false
+		case inTransaction && ddlStatement.MatchString(upper):
+			warn(LintImplicitCommit, "statement causes an implicit commit inside of a transaction")
+		}
+
+		if strings.HasPrefix(upper, "RENAME ") {
+			warn(LintRename, "renaming breaks any running code that uses the old name")
+		}
+
+		if createTable.MatchString(upper) {
+			lintForeignKeys(upper, splitClauses(tableBody(upper)), warn)
+		}
+
+		if alterTable.MatchString(upper) {
+			lintAlterTable(upper, warn)
+		}
+	}
+
+	return warnings
+}
+
+// check an alter table statement clause by clause
+func lintAlterTable(upper string, warn func(rule, message string)) {
+	if !algorithmNone.MatchString(upper) || !lockNone.MatchString(upper) {
+		warn(LintAlterLock, "ALTER TABLE without ALGORITHM=INPLACE, LOCK=NONE may lock the table")
+	}
+
+	// everything after the table name is a comma separated list of changes
+	parts := strings.SplitN(alterTable.ReplaceAllString(upper, ""), " ", 3)
+	clauses := make([]string, 0)
+
+	if len(parts) == 3 {
+		clauses = splitClauses(parts[2])
+	}
+
+	for c := range clauses {
+		clause := clauses[c]
+
+		if m := dropColumn.FindStringSubmatch(clause); m != nil && !notColumns[m[2]] {
+			warn(LintDropColumn, "dropping a column loses its data: "+clause)
+		}
+
+		if renameClause.MatchString(clause) {
+			warn(LintRename, "renaming breaks any running code that uses the old name: "+clause)
+		}
+
+		if m := addColumn.FindStringSubmatch(clause); m != nil && !notColumns[m[2]] {
+			if strings.Contains(clause, "NOT NULL") && !strings.Contains(clause, "DEFAULT") && !strings.Contains(clause, "AUTO_INCREMENT") {
+				warn(LintNotNullWithoutDefault, "adding a NOT NULL column without a default fails or rewrites existing rows: "+clause)
+			}
+		}
+	}
+
+	lintForeignKeys(upper, clauses, warn)
+}
+
+// warn about foreign keys whose columns are not the leading columns of an index in the same statement
+func lintForeignKeys(upper string, clauses []string, warn func(rule, message string)) {
+	indexed := make([]string, 0)
+
+	for c := range clauses {
+		if m := indexClause.FindStringSubmatch(clauses[c]); m != nil {
+			indexed = append(indexed, normalizeColumns(m[6]))
+		}
+	}
+
+	for _, m := range foreignKey.FindAllStringSubmatch(upper, -1) {
+		cols := normalizeColumns(m[2])
+		found := false
+
+		for i := range indexed {
+			if indexed[i] == cols || strings.HasPrefix(indexed[i], cols+",") {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			warn(LintForeignKeyIndex, "foreign key on ("+cols+") has no index")
+		}
+	}
+}
+
+// strip quotes and spaces from a column list
+func normalizeColumns(cols string) string {
+	return strings.NewReplacer("`", "", `"`, "", " ", "").Replace(cols)
+}
+
+// return the part of a create table statement between the outer parentheses
+func tableBody(upper string) string {
+	start := strings.Index(upper, "(")
+	end := strings.LastIndex(upper, ")")
+
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return upper[start+1 : end]
+}
+
+// split on commas that are not inside of parentheses
+func splitClauses(body string) []string {
+	clauses := make([]string, 0)
+	depth := 0
+	last := 0
+
+	for i, r := range body {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				clauses = append(clauses, strings.TrimSpace(body[last:i]))
+				last = i + 1
+			}
+		}
+	}
+
+	if rest := strings.TrimSpace(body[last:]); rest != "" {
+		clauses = append(clauses, rest)
+	}
+
+	return clauses
+}
+
+// split sql into statements, keeping track of comments and the line each statement starts on. Comments belong
+// to the statement after them, unless they follow a statement on the same line. Quoted strings and comments may
+// contain semicolons.
+func splitStatements(sql string) []statement {
+	statements := make([]statement, 0)
+	current := statement{}
+	var buf, comments strings.Builder
+	line, start := 1, 0
+	trailing := false // whether a statement ended earlier on the current line
+
+	comment := func(text string) {
+		if trailing && strings.TrimSpace(buf.String()) == "" {
+			statements[len(statements)-1].comments += text
+		} else {
+			comments.WriteString(text)
+		}
+	}
+
+	flush := func(end int) {
+		current.sql = strings.TrimSpace(buf.String())
+		current.comments = comments.String()
+		current.start, current.end = start, end
+
+		trailing = current.sql != ""
+
+		if trailing {
+			statements = append(statements, current)
+		}
+
+		current = statement{}
+		start = end
+		buf.Reset()
+		comments.Reset()
+	}
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+
+		switch {
+		case c == '\n':
+			line++
+			trailing = false
+			buf.WriteByte(c)
+
+		case c == '#' || (c == '-' && strings.HasPrefix(sql[i:], "--")):
+			end := strings.IndexByte(sql[i:], '\n')
+
+			if end < 0 {
+				end = len(sql) - i
+			}
+
+			comment(sql[i:i+end] + "\n")
+			i += end - 1
+
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+
+			if end < 0 {
+				end = len(sql) - i - 2
+			}
+
+			text := sql[i : i+end+2]
+			comment(text + "\n")
+			line += strings.Count(text, "\n")
+			i += end + 3
+
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+
+			for end < len(sql) && sql[end] != c {
+				if sql[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end >= len(sql) {
+				end = len(sql) - 1
+			}
+
+			if current.line == 0 {
+				current.line = line
+			}
+
+			buf.WriteString(sql[i : end+1])
+			line += strings.Count(sql[i:end+1], "\n")
+			i = end
+
+		case c == ';':
+			flush(i + 1)
+
+		default:
+			if current.line == 0 && c != ' ' && c != '\t' && c != '\r' {
+				current.line = line
+			}
+
+			buf.WriteByte(c)
+		}
+	}
+
+	flush(len(sql))
+
+	return statements
+}
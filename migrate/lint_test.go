@@ -0,0 +1,120 @@
+package migrate_test
+
+import (
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintSQL(t *testing.T) {
+	rules := func(warnings []migrate.LintWarning) []string {
+		found := make([]string, 0)
+		for w := range warnings {
+			found = append(found, warnings[w].Rule)
+		}
+		return found
+	}
+
+	t.Run("it does not warn about safe statements", func(t *testing.T) {
+		warnings := migrate.LintSQL(`
+			CREATE TABLE users (id INT AUTO_INCREMENT, name VARCHAR(32), PRIMARY KEY (id));
+			ALTER TABLE users ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT '', ALGORITHM=INPLACE, LOCK=NONE;
+			INSERT INTO users (name) VALUES ('drop column; rename');
+		`, nil)
+		assert.Len(t, warnings, 0, "should not return warnings")
+	})
+
+	t.Run("it warns about dropped columns and locking alters", func(t *testing.T) {
+		warnings := migrate.LintSQL("ALTER TABLE users DROP COLUMN email, DROP INDEX idx_name;", nil)
+		assert.ElementsMatch(t, []string{migrate.LintAlterLock, migrate.LintDropColumn}, rules(warnings))
+	})
+
+	t.Run("it warns about renames", func(t *testing.T) {
+		warnings := migrate.LintSQL("RENAME TABLE users TO people;", nil)
+		assert.Equal(t, []string{migrate.LintRename}, rules(warnings))
+	})
+
+	t.Run("it warns about not null columns without defaults", func(t *testing.T) {
+		warnings := migrate.LintSQL("ALTER TABLE users ADD name VARCHAR(32) NOT NULL, ALGORITHM=INPLACE, LOCK=NONE;", nil)
+		assert.Equal(t, []string{migrate.LintNotNullWithoutDefault}, rules(warnings))
+	})
+
+	t.Run("it warns about foreign keys without indexes", func(t *testing.T) {
+		warnings := migrate.LintSQL(`
+			CREATE TABLE posts (
+				id INT AUTO_INCREMENT,
+				user_id INT NOT NULL,
+				PRIMARY KEY (id),
+				FOREIGN KEY (user_id) REFERENCES users (id)
+			);
+		`, nil)
+		assert.Equal(t, []string{migrate.LintForeignKeyIndex}, rules(warnings))
+
+		warnings = migrate.LintSQL(`
+			CREATE TABLE posts (
+				id INT AUTO_INCREMENT,
+				user_id INT NOT NULL,
+				PRIMARY KEY (id),
+				INDEX idx_user_id (user_id),
+				FOREIGN KEY (user_id) REFERENCES users (id)
+			);
+		`, nil)
+		assert.Len(t, warnings, 0, "should not warn about indexed foreign keys")
+	})
+
+	t.Run("it warns about implicit commits inside transactions", func(t *testing.T) {
+		warnings := migrate.LintSQL("START TRANSACTION; CREATE TABLE foo (id INT); COMMIT; CREATE TABLE bar (id INT);", nil)
+		assert.Equal(t, []string{migrate.LintImplicitCommit}, rules(warnings))
+	})
+
+	t.Run("it skips disabled rules", func(t *testing.T) {
+		warnings := migrate.LintSQL("ALTER TABLE users DROP COLUMN email;", []string{migrate.LintAlterLock, migrate.LintDropColumn})
+		assert.Len(t, warnings, 0, "should not return warnings")
+	})
+
+	t.Run("it skips rules suppressed by comments", func(t *testing.T) {
+		warnings := migrate.LintSQL(`
+			-- migrant:ignore drop_column
+			ALTER TABLE users DROP COLUMN email;
+
+			-- migrant:ignore
+			RENAME TABLE users TO people;
+		`, nil)
+		assert.Equal(t, []string{migrate.LintAlterLock}, rules(warnings))
+	})
+
+	t.Run("it applies comments after a statement on the same line to that statement", func(t *testing.T) {
+		warnings := migrate.LintSQL(`
+			ALTER TABLE users DROP COLUMN email; -- migrant:ignore drop_column, alter_lock
+			RENAME TABLE users TO people;
+		`, nil)
+		assert.Equal(t, []string{migrate.LintRename}, rules(warnings), "should only suppress the statement before")
+
+		warnings = migrate.LintSQL(`
+			ALTER TABLE users DROP COLUMN email;
+			-- migrant:ignore drop_column, alter_lock
+			ALTER TABLE people DROP COLUMN email;
+		`, nil)
+		assert.Equal(t, []string{migrate.LintAlterLock, migrate.LintDropColumn}, rules(warnings), "should suppress the statement after")
+	})
+}
+
+func TestLintMigration(t *testing.T) {
+	t.Run("it lints a migration file", func(t *testing.T) {
+		warnings, err := migrate.LintMigration("../fixtures/lint/20190101001122_dangerous.sql", nil)
+		assert.Nil(t, err, "should not return an error")
+		assert.Len(t, warnings, 4, "should return 4 warnings")
+
+		assert.Equal(t, 2, warnings[0].Line, "should report the line of the statement")
+		assert.Equal(t, "../fixtures/lint/20190101001122_dangerous.sql", warnings[0].Path, "should report the path")
+		assert.Equal(t, migrate.LintNotNullWithoutDefault, warnings[2].Rule)
+		assert.Equal(t, 5, warnings[2].Line, "should report the line of the statement")
+		assert.Equal(t, migrate.LintRename, warnings[3].Rule)
+	})
+
+	t.Run("it returns an error if the file does not exist", func(t *testing.T) {
+		_, err := migrate.LintMigration("./this-file-does-not-exist", nil)
+		assert.NotNil(t, err, "should return an error")
+	})
+}
@@ -0,0 +1,419 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// OnlineOptions controls how statements marked with `-- migrant:online` are applied.
+type OnlineOptions struct {
+	ChunkSize      int64                     // rows copied per chunk
+	ChunkPause     time.Duration             // pause between chunks
+	MaxReplicaLag  time.Duration             // copying waits while any replica lags more than this
+	MaxReplicaWait time.Duration             // the alter fails if a replica is still lagging after this long
+	Replicas       []*sql.DB                 // replicas to check for lag
+	KeepOldTable   bool                      // keep the original table after cut-over
+	Progress       func(copied, total int64) // called after each chunk is copied
+
+	// called while copying waits for a replica, with the lag of the replica, which is negative if replication is
+	// not running
+	Waiting func(replica int, lag time.Duration)
+}
+
+// DefaultOnlineOptions returns the options used when none are configured.
+func DefaultOnlineOptions() OnlineOptions {
+	return OnlineOptions{
+		ChunkSize:      1000,
+		ChunkPause:     0,
+		MaxReplicaLag:  5 * time.Second,
+		MaxReplicaWait: 10 * time.Minute,
+	}
+}
+
+var (
+	onlineMarker  = regexp.MustCompile(`migrant:online\b`)
+	onlineAlter   = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(\S+)\s+(.+)$`)
+	onlineLagPoll = time.Second
+)
+
+// strip the quotes from a table name. Names with a schema, like `shop`.`orders`, are refused, since the shadow
+// table, the triggers and the lookups in information_schema all work on the database that is connected to.
+func onlineTableName(name string) (string, error) {
+	var table strings.Builder
+	var quote byte
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+
+		switch {
+		case quote == 0 && (c == '`' || c == '"'):
+			quote = c
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && c == '.':
+			return "", fmt.Errorf("online alters only work on tables in the current database, remove the schema from %s", name)
+		default:
+			table.WriteByte(c)
+		}
+	}
+
+	return table.String(), nil
+}
+
+// HasOnlineStatements returns true if the sql contains any statements marked for online execution.
+func HasOnlineStatements(sql string) bool {
+	return onlineMarker.MatchString(sql)
+}
+
+// OnlineAlterTable applies the alter clauses to a table without locking it. A shadow copy of the table is
+// created and altered, triggers keep it in sync with the original while rows are copied over in chunks, and
+// finally the tables are swapped with an atomic rename. The table must have a single column integer primary key,
+// and cannot have foreign keys or be referenced by them, since they would not follow the table when it is swapped.
+// The table must be in the database that is connected to, and named without a schema.
+func OnlineAlterTable(db *sql.DB, table, clauses string, options OnlineOptions) (err error) {
+	table, err = onlineTableName(table)
+
+	if err != nil {
+		return err
+	}
+	shadow := "_" + table + "_new"
+	old := "_" + table + "_old"
+	triggers := []string{"migrant_" + table + "_ins", "migrant_" + table + "_upd", "migrant_" + table + "_del"}
+
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = DefaultOnlineOptions().ChunkSize
+	}
+
+	if options.MaxReplicaWait <= 0 {
+		options.MaxReplicaWait = DefaultOnlineOptions().MaxReplicaWait
+	}
+
+	pk, err := primaryKey(db, table)
+
+	if err != nil {
+		return err
+	}
+
+	var foreignKeys int
+
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.KEY_COLUMN_USAGE
+		WHERE REFERENCED_TABLE_NAME IS NOT NULL AND (
+			(TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?) OR
+			(REFERENCED_TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME = ?)
+		)
+	`, table, table).Scan(&foreignKeys)
+
+	if err != nil {
+		return err
+	}
+
+	if foreignKeys > 0 {
+		return fmt.Errorf("online alter cannot be used on %s because it has foreign keys", table)
+	}
+
+	// clean up the shadow table and triggers if anything goes wrong before cut-over
+	cutOver := false
+
+	defer func() {
+		if err != nil && !cutOver {
+			dropTriggers(db, triggers)
+			db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS `%s`", shadow))
+		}
+	}()
+
+	if err = execAll(db,
+		fmt.Sprintf("CREATE TABLE `%s` LIKE `%s`", shadow, table),
+		fmt.Sprintf("ALTER TABLE `%s` %s", shadow, clauses),
+	); err != nil {
+		return err
+	}
+
+	cols, err := commonColumns(db, table, shadow)
+
+	if err != nil {
+		return err
+	}
+
+	if !contains(cols, pk) {
+		return fmt.Errorf("online alter cannot drop the primary key column %s of %s", pk, table)
+	}
+
+	colList := "`" + strings.Join(cols, "`, `") + "`"
+	newList := "NEW.`" + strings.Join(cols, "`, NEW.`") + "`"
+
+	if err = execAll(db,
+		fmt.Sprintf("CREATE TRIGGER `%s` AFTER INSERT ON `%s` FOR EACH ROW REPLACE INTO `%s` (%s) VALUES (%s)", triggers[0], table, shadow, colList, newList),
+		fmt.Sprintf("CREATE TRIGGER `%s` AFTER UPDATE ON `%s` FOR EACH ROW BEGIN DELETE IGNORE FROM `%s` WHERE `%s` = OLD.`%s` AND OLD.`%s` <> NEW.`%s`; REPLACE INTO `%s` (%s) VALUES (%s); END", triggers[1], table, shadow, pk, pk, pk, pk, shadow, colList, newList),
+		fmt.Sprintf("CREATE TRIGGER `%s` AFTER DELETE ON `%s` FOR EACH ROW DELETE IGNORE FROM `%s` WHERE `%s` = OLD.`%s`", triggers[2], table, shadow, pk, pk),
+	); err != nil {
+		return err
+	}
+
+	if err = copyChunks(db, table, shadow, pk, colList, options); err != nil {
+		return err
+	}
+
+	// swap the tables in a single atomic rename
+	if _, err = db.Exec(fmt.Sprintf("RENAME TABLE `%s` TO `%s`, `%s` TO `%s`", table, old, shadow, table)); err != nil {
+		return err
+	}
+
+	cutOver = true
+
+	if err = dropTriggers(db, triggers); err != nil {
+		return err
+	}
+
+	if !options.KeepOldTable {
+		_, err = db.Exec(fmt.Sprintf("DROP TABLE `%s`", old))
+	}
+
+	return err
+}
+
+// copy rows from the table into the shadow table one primary key range at a time
+func copyChunks(db *sql.DB, table, shadow, pk, colList string, options OnlineOptions) error {
+	var min, max sql.NullInt64
+	var total int64
+
+	err := db.QueryRow(fmt.Sprintf("SELECT MIN(`%s`), MAX(`%s`), COUNT(*) FROM `%s`", pk, pk, table)).Scan(&min, &max, &total)
+
+	if err != nil {
+		return err
+	}
+
+	if !min.Valid {
+		return nil
+	}
+
+	var copied int64
+
+	for start := min.Int64; start <= max.Int64; start += options.ChunkSize {
+		if err := waitForReplicas(options); err != nil {
+			return err
+		}
+
+		res, err := db.Exec(fmt.Sprintf(
+			"INSERT LOW_PRIORITY IGNORE INTO `%s` (%s) SELECT %s FROM `%s` WHERE `%s` >= ? AND `%s` < ? LOCK IN SHARE MODE",
+			shadow, colList, colList, table, pk, pk,
+		), start, start+options.ChunkSize)
+
+		if err != nil {
+			return err
+		}
+
+		rows, _ := res.RowsAffected()
+		copied += rows
+
+		if options.Progress != nil {
+			options.Progress(copied, total)
+		}
+
+		time.Sleep(options.ChunkPause)
+	}
+
+	return nil
+}
+
+// block until every replica is within the allowed lag, or return an error if one of them is still lagging after
+// the longest wait
+func waitForReplicas(options OnlineOptions) error {
+	for r := range options.Replicas {
+		started := time.Now()
+
+		for {
+			lag, err := ReplicaLag(options.Replicas[r])
+
+			if err != nil {
+				return err
+			}
+
+			if lag >= 0 && lag <= options.MaxReplicaLag {
+				break
+			}
+
+			if time.Since(started) >= options.MaxReplicaWait {
+				if lag < 0 {
+					return fmt.Errorf("replication is not running on replica %d, gave up after waiting %s", r+1, options.MaxReplicaWait)
+				}
+
+				return fmt.Errorf("replica %d is still %s behind, gave up after waiting %s", r+1, lag, options.MaxReplicaWait)
+			}
+
+			if options.Waiting != nil {
+				options.Waiting(r, lag)
+			}
+
+			time.Sleep(onlineLagPoll)
+		}
+	}
+
+	return nil
+}
+
+// ReplicaLag returns how far behind its master a replica is. A negative duration means replication is not
+// running, so the lag is unknown.
+func ReplicaLag(db *sql.DB) (time.Duration, error) {
+	rows, err := db.Query("SHOW SLAVE STATUS")
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !rows.Next() {
+		return 0, fmt.Errorf("server is not a replica")
+	}
+
+	vals := make([]sql.RawBytes, len(cols))
+	ptrs := make([]interface{}, len(cols))
+
+	for v := range vals {
+		ptrs[v] = &vals[v]
+	}
+
+	if err := rows.Scan(ptrs...); err != nil {
+		return 0, err
+	}
+
+	for c := range cols {
+		if cols[c] != "Seconds_Behind_Master" {
+			continue
+		}
+
+		if vals[c] == nil {
+			return -1, nil
+		}
+
+		seconds, err := time.ParseDuration(string(vals[c]) + "s")
+
+		if err != nil {
+			return 0, err
+		}
+
+		return seconds, nil
+	}
+
+	return 0, fmt.Errorf("could not find Seconds_Behind_Master in replica status")
+}
+
+// find the single column primary key of a table
+func primaryKey(db *sql.DB, table string) (string, error) {
+	rows, err := db.Query(`
+		SELECT k.COLUMN_NAME, c.DATA_TYPE FROM information_schema.KEY_COLUMN_USAGE k
+		JOIN information_schema.COLUMNS c
+			ON c.TABLE_SCHEMA = k.TABLE_SCHEMA AND c.TABLE_NAME = k.TABLE_NAME AND c.COLUMN_NAME = k.COLUMN_NAME
+		WHERE k.TABLE_SCHEMA = DATABASE() AND k.TABLE_NAME = ? AND k.CONSTRAINT_NAME = 'PRIMARY'
+	`, table)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	keys := make([]string, 0)
+	var dataType string
+
+	for rows.Next() {
+		var key string
+
+		if err := rows.Scan(&key, &dataType); err != nil {
+			return "", err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) != 1 || !strings.HasSuffix(strings.ToLower(dataType), "int") {
+		return "", fmt.Errorf("online alter needs a single column integer primary key on %s", table)
+	}
+
+	return keys[0], nil
+}
+
+// return the columns that exist in both tables, in the order of the first
+func commonColumns(db *sql.DB, a, b string) ([]string, error) {
+	colsA, err := tableColumns(db, a)
+
+	if err != nil {
+		return nil, err
+	}
+
+	colsB, err := tableColumns(db, b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	common := make([]string, 0)
+
+	for c := range colsA {
+		if contains(colsB, colsA[c]) {
+			common = append(common, colsA[c])
+		}
+	}
+
+	return common, nil
+}
+
+// return the column names of a table in order
+func tableColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION
+	`, table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	cols := make([]string, 0)
+
+	for rows.Next() {
+		var c string
+
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+
+		cols = append(cols, c)
+	}
+
+	return cols, nil
+}
+
+// drop triggers if they exist
+func dropTriggers(db *sql.DB, triggers []string) error {
+	for t := range triggers {
+		if _, err := db.Exec(fmt.Sprintf("DROP TRIGGER IF EXISTS `%s`", triggers[t])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns true if the list contains the string
+func contains(list []string, s string) bool {
+	for l := range list {
+		if list[l] == s {
+			return true
+		}
+	}
+
+	return false
+}
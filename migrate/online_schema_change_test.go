@@ -0,0 +1,101 @@
+package migrate_test
+
+import (
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnlineAlterTable(t *testing.T) {
+	mustExec(`
+		CREATE TABLE test_table_2 (
+			id INT AUTO_INCREMENT,
+			name VARCHAR(32),
+			PRIMARY KEY (id)
+		);
+	`, `INSERT INTO test_table_2 (name) VALUES ("foo"), ("bar"), ("baz"), ("qux"), ("quux");`)
+
+	defer mustExec("DROP TABLE IF EXISTS test_table_2")
+
+	t.Run("it alters the table and keeps its data", func(t *testing.T) {
+		options := migrate.DefaultOnlineOptions()
+		options.ChunkSize = 2
+		chunks := 0
+		options.Progress = func(copied, total int64) { chunks++ }
+
+		err := migrate.OnlineAlterTable(db, "test_table_2", "ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT ''", options)
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, 3, chunks, "should copy rows in chunks")
+
+		_, err = db.Exec("SELECT email FROM test_table_2")
+		assert.Nil(t, err, "should have added the column")
+		assert.Equal(t, int64(5), getRowCount("test_table_2"), "should have kept all the rows")
+
+		// the original table was swapped out and triggers were cleaned up
+		assert.Equal(t, 1, countTables(), "should not leave shadow tables behind")
+		assert.Equal(t, int64(0), getRowCount("information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = DATABASE()"), "should drop triggers")
+	})
+
+	t.Run("it cleans up when the alter fails", func(t *testing.T) {
+		err := migrate.OnlineAlterTable(db, "test_table_2", "ADD COLUMN bogus BOGUS", migrate.DefaultOnlineOptions())
+		assert.NotNil(t, err, "should return an error")
+		assert.Equal(t, 1, countTables(), "should not leave shadow tables behind")
+	})
+
+	t.Run("it refuses tables named with a schema", func(t *testing.T) {
+		for _, table := range []string{"test.test_table_2", "`test`.`test_table_2`"} {
+			err := migrate.OnlineAlterTable(db, table, "ADD COLUMN email VARCHAR(255)", migrate.DefaultOnlineOptions())
+			assert.NotNil(t, err, "should return an error")
+			assert.Contains(t, err.Error(), "remove the schema", "should say why")
+		}
+
+		assert.Equal(t, 1, countTables(), "should not create shadow tables")
+	})
+
+	t.Run("it needs an integer primary key", func(t *testing.T) {
+		mustExec("CREATE TABLE test_table_3 (name VARCHAR(32))")
+		defer mustExec("DROP TABLE test_table_3")
+
+		err := migrate.OnlineAlterTable(db, "test_table_3", "ADD COLUMN email VARCHAR(255)", migrate.DefaultOnlineOptions())
+		assert.NotNil(t, err, "should return an error")
+	})
+
+	t.Run("it refuses tables with foreign keys", func(t *testing.T) {
+		dropTestTables := mustHaveTestTables()
+		defer dropTestTables()
+
+		err := migrate.OnlineAlterTable(db, "test_table_1", "ADD COLUMN email VARCHAR(255)", migrate.DefaultOnlineOptions())
+		assert.NotNil(t, err, "should return an error")
+	})
+}
+
+func TestApplyOnlineMigrations(t *testing.T) {
+	closeMigrations := mustAddMigrations()
+	defer closeMigrations()
+
+	mustExec("CREATE TABLE test_table_2 (id INT AUTO_INCREMENT, PRIMARY KEY (id))")
+	defer mustExec("DROP TABLE IF EXISTS test_table_2", "DROP TABLE IF EXISTS test_table_3")
+
+	migrations := []migrate.MigrationFile{
+		{
+			Prefix: "20190101001122",
+			Path:   "../fixtures/migrations_online/20190101001122_online_alter.sql",
+			Desc:   "online alter",
+		},
+	}
+
+	t.Run("it applies statements marked as online", func(t *testing.T) {
+		err := migrate.ApplyMigrations(db, migrations)
+		assert.Nil(t, err, "should return no error")
+
+		_, err = db.Exec("SELECT count(*) FROM test_table_3")
+		assert.Nil(t, err, "should run statements that are not online")
+
+		_, err = db.Exec("SELECT email FROM test_table_2")
+		assert.Nil(t, err, "should run online statements")
+
+		mustExec("INSERT INTO test_table_3 (id) VALUES (1)")
+		assert.Equal(t, int64(1), getRowCount("test_table_3 WHERE id = 4"), "should not split the trigger apart")
+	})
+}
@@ -0,0 +1,45 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// column specs look like: users.email:varchar(255)
+var columnSpec = regexp.MustCompile(`^(\w+)\.(\w+):(.+)$`)
+
+// ScaffoldCreateTable returns the DDL for creating a new table with an id and timestamps in the
+// dialect of the given driver.
+func ScaffoldCreateTable(driver, table string) (string, error) {
+	switch driver {
+	case "mysql":
+		return fmt.Sprintf("CREATE TABLE `%s` (\n"+
+			"    `id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,\n"+
+			"    `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,\n"+
+			"    `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n"+
+			"    PRIMARY KEY (`id`)\n"+
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n", table), nil
+	}
+
+	return "", fmt.Errorf("cannot scaffold tables for driver: %s", driver)
+}
+
+// ScaffoldAddColumn returns the DDL for adding a column described by a spec such as
+// users.email:varchar(255) in the dialect of the given driver.
+func ScaffoldAddColumn(driver, spec string) (string, error) {
+	matches := columnSpec.FindStringSubmatch(spec)
+
+	if len(matches) < 4 {
+		return "", fmt.Errorf("column must be described as table.column:type, got: %s", spec)
+	}
+
+	table, column, colType := matches[1], matches[2], strings.TrimSpace(matches[3])
+
+	switch driver {
+	case "mysql":
+		return fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s;\n", table, column, colType), nil
+	}
+
+	return "", fmt.Errorf("cannot scaffold columns for driver: %s", driver)
+}
@@ -0,0 +1,35 @@
+package migrate_test
+
+import (
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaffoldCreateTable(t *testing.T) {
+	t.Run("it scaffolds a mysql table", func(t *testing.T) {
+		sql, err := migrate.ScaffoldCreateTable("mysql", "users")
+		assert.Nil(t, err, "should not return an error")
+		assert.Contains(t, sql, "CREATE TABLE `users`", "should quote table name with backticks")
+		assert.Contains(t, sql, "AUTO_INCREMENT", "should use mysql auto increment")
+	})
+
+	t.Run("it returns an error for unknown drivers", func(t *testing.T) {
+		_, err := migrate.ScaffoldCreateTable("bogus", "users")
+		assert.NotNil(t, err, "should return an error")
+	})
+}
+
+func TestScaffoldAddColumn(t *testing.T) {
+	t.Run("it scaffolds a new column", func(t *testing.T) {
+		sql, err := migrate.ScaffoldAddColumn("mysql", "users.email:varchar(255)")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "ALTER TABLE `users` ADD COLUMN `email` varchar(255);\n", sql)
+	})
+
+	t.Run("it returns an error for a bad column spec", func(t *testing.T) {
+		_, err := migrate.ScaffoldAddColumn("mysql", "email:varchar(255)")
+		assert.NotNil(t, err, "should return an error")
+	})
+}
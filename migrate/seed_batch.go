@@ -0,0 +1,123 @@
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DefaultSeedBatchSize is the number of rows inserted by each statement when no batch size is set.
+const DefaultSeedBatchSize = 100
+
+// the most placeholders a single statement may use for each driver
+var placeholderLimits = map[string]int{
+	"mysql": 65535,
+}
+
+// seedBatch groups rows for the same table and columns into multi-row insert statements. Once a batch is
+// flushed, the generated id of each row is passed to record along with the row's reference name.
+type seedBatch struct {
+	tx        *sql.Tx
+	size      int
+	limit     int
+	increment int64
+	table     string
+	cols      []string
+	rows      [][]interface{}
+	refs      []string
+	record    func(table string, id int64, ref string)
+}
+
+// create a new batch. Mysql hands out consecutive ids to the rows of a multi-row insert, spaced by the
+// auto_increment_increment setting, which is looked up here, and reports the first of them as the last insert
+// id.
+func newSeedBatch(tx *sql.Tx, driver string, size int, record func(table string, id int64, ref string)) (*seedBatch, error) {
+	if size <= 0 {
+		size = DefaultSeedBatchSize
+	}
+
+	b := seedBatch{tx: tx, size: size, limit: placeholderLimits[driver], increment: 1, record: record}
+
+	if driver == "mysql" {
+		if err := tx.QueryRow("SELECT @@auto_increment_increment").Scan(&b.increment); err != nil {
+			return nil, err
+		}
+	}
+
+	return &b, nil
+}
+
+// add a row to the batch, flushing first if the row is for a different table or set of columns, or if the
+// batch is full.
+func (b *seedBatch) add(table string, cols []string, vals []interface{}, ref string) error {
+	if len(b.rows) > 0 && (table != b.table || strings.Join(cols, ",") != strings.Join(b.cols, ",")) {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+
+	b.table = table
+	b.cols = cols
+	b.rows = append(b.rows, vals)
+	b.refs = append(b.refs, ref)
+
+	// stay under the placeholder limit of the driver
+	maxRows := b.size
+
+	if b.limit > 0 && len(cols) > 0 && maxRows*len(cols) > b.limit {
+		maxRows = b.limit / len(cols)
+	}
+
+	if len(b.rows) >= maxRows {
+		return b.flush()
+	}
+
+	return nil
+}
+
+// pending returns true if the batch holds rows that have not been inserted yet.
+func (b *seedBatch) pending() bool {
+	return len(b.rows) > 0
+}
+
+// insert every row in the batch with a single statement
+func (b *seedBatch) flush() error {
+	if len(b.rows) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(b.cols))
+
+	for c := range b.cols {
+		quoted[c] = "`" + b.cols[c] + "`"
+	}
+
+	tuples := make([]string, len(b.rows))
+	args := make([]interface{}, 0)
+
+	for r := range b.rows {
+		values, rowArgs := valuesClause(b.rows[r])
+		tuples[r] = "(" + values + ")"
+		args = append(args, rowArgs...)
+	}
+
+	q := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES %s", b.table, strings.Join(quoted, ", "), strings.Join(tuples, ", "))
+	res, err := b.tx.Exec(q, args...)
+
+	if err != nil {
+		return err
+	}
+
+	firstId, err := res.LastInsertId()
+
+	if err == nil {
+		for r := range b.rows {
+			b.record(b.table, firstId+int64(r)*b.increment, b.refs[r])
+		}
+	}
+
+	b.rows = nil
+	b.refs = nil
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+var (
+	tableDirective = regexp.MustCompile(`^#\s*table:\s*(\S+)\s*$`)
+	seedFilePrefix = regexp.MustCompile(`^v?\d+(\.\d+)*_`)
+)
+
+// the byte order mark that some editors, like excel, write at the start of utf-8 files
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// CSVNull is the csv value that is inserted as null, the same one that mysql uses for LOAD DATA.
+const CSVNull = `\N`
+
+// IsSeedFile returns true if the file has the extension of a seed file format.
+func IsSeedFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json", ".ndjson", ".csv":
+		return true
+	}
+
+	return false
+}
+
+// ReadSeedFile reads a seed file into a SeedInput. The format is picked by the file extension: yaml and json
+// files describe a SeedInput, while csv, ndjson and json files holding an array describe rows for one table.
+func ReadSeedFile(path string) (SeedInput, error) {
+	var input SeedInput
+	contents, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return input, err
+	}
+
+	contents = bytes.TrimPrefix(contents, utf8BOM)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &input)
+
+	case ".json":
+		if trimmed := bytes.TrimSpace(contents); len(trimmed) > 0 && trimmed[0] == '[' {
+			input, err = readJSONRows(path, contents)
+		} else {
+			err = json.Unmarshal(contents, &input)
+		}
+
+	case ".ndjson":
+		input, err = readNDJSONRows(path, contents)
+
+	case ".csv":
+		input, err = readCSVRows(path, contents)
+
+	default:
+		err = fmt.Errorf("unknown seed file format: %s", path)
+	}
+
+	if err != nil {
+		return input, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return input, nil
+}
+
+// the table for files that hold rows for a single table is named after the file, without any version prefix
+func tableFromFileName(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return seedFilePrefix.ReplaceAllString(name, "")
+}
+
+// read csv rows. The first row names the columns. A `# table: name` line before it names the table, otherwise
+// the table is named after the file. Values that are CSVNull are inserted as null.
+func readCSVRows(path string, contents []byte) (SeedInput, error) {
+	table, contents := readTableDirective(path, contents)
+	reader := csv.NewReader(bytes.NewReader(contents))
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return SeedInput{}, err
+	}
+
+	seed := Seed{Table: table, Insert: make([]SeedRow, 0)}
+
+	if len(records) == 0 {
+		return SeedInput{Seeds: []Seed{seed}}, nil
+	}
+
+	cols := records[0]
+
+	for _, record := range records[1:] {
+		row := make(SeedRow)
+
+		for c := range cols {
+			if record[c] == CSVNull {
+				row[cols[c]] = nil
+			} else {
+				row[cols[c]] = record[c]
+			}
+		}
+
+		seed.Insert = append(seed.Insert, row)
+	}
+
+	return SeedInput{Seeds: []Seed{seed}}, nil
+}
+
+// read a json array of rows for the table named after the file
+func readJSONRows(path string, contents []byte) (SeedInput, error) {
+	var rows []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&rows); err != nil {
+		return SeedInput{}, err
+	}
+
+	return jsonRowsToInput(tableFromFileName(path), rows)
+}
+
+// read one json row per line. A `# table: name` line at the top names the table, otherwise the table is named
+// after the file.
+func readNDJSONRows(path string, contents []byte) (SeedInput, error) {
+	table, contents := readTableDirective(path, contents)
+	rows := make([]map[string]interface{}, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var row map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+
+		if err := decoder.Decode(&row); err != nil && err != io.EOF {
+			return SeedInput{}, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		if row == nil {
+			return SeedInput{}, fmt.Errorf("line %d: must be a json object", line)
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return SeedInput{}, err
+	}
+
+	return jsonRowsToInput(table, rows)
+}
+
+// convert decoded json rows into seed rows
+func jsonRowsToInput(table string, rows []map[string]interface{}) (SeedInput, error) {
+	seed := Seed{Table: table, Insert: make([]SeedRow, 0)}
+
+	for r := range rows {
+		row, err := jsonSeedRow(rows[r])
+
+		if err != nil {
+			return SeedInput{}, err
+		}
+
+		seed.Insert = append(seed.Insert, row)
+	}
+
+	return SeedInput{Seeds: []Seed{seed}}, nil
+}
+
+// look for a `# table: name` line at the top of a file, returning the table and the rest of the file. If there
+// is no directive the table is named after the file.
+func readTableDirective(path string, contents []byte) (string, []byte) {
+	firstLine := contents
+
+	if end := bytes.IndexByte(contents, '\n'); end >= 0 {
+		firstLine = contents[:end]
+	}
+
+	matches := tableDirective.FindSubmatch(bytes.TrimSpace(firstLine))
+
+	if matches == nil {
+		return tableFromFileName(path), contents
+	}
+
+	return string(matches[1]), contents[len(firstLine):]
+}
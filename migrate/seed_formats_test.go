@@ -0,0 +1,164 @@
+package migrate_test
+
+import (
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSeedFile(t *testing.T) {
+	t.Run("it reads csv files", func(t *testing.T) {
+		input, err := migrate.ReadSeedFile("../fixtures/seeds4/20190101001122_test_table_1.csv")
+		assert.Nil(t, err, "should not return an error")
+		assert.Len(t, input.Seeds, 1)
+		assert.Equal(t, "test_table_1", input.Seeds[0].Table, "should name the table after the file")
+		assert.Equal(t, []migrate.SeedRow{{"_ref": "first", "name": "csv 1"}, {"_ref": "second", "name": "csv, 2"}}, input.Seeds[0].Insert)
+	})
+
+	t.Run("it reads the table from a directive", func(t *testing.T) {
+		input, err := migrate.ReadSeedFile("../fixtures/seeds4/20190102001122_links.csv")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "link_table_1", input.Seeds[0].Table, "should use the table directive")
+		assert.Equal(t, `{{ ref "test_table_1" "second" }}`, input.Seeds[0].Insert[0]["test_table_id"])
+	})
+
+	t.Run("it reads json rows", func(t *testing.T) {
+		input, err := migrate.ReadSeedFile("../fixtures/seeds4/20190103001122_test_table_1.json")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "test_table_1", input.Seeds[0].Table)
+		assert.Equal(t, []migrate.SeedRow{{"name": "json 1"}}, input.Seeds[0].Insert)
+	})
+
+	t.Run("it reads ndjson rows", func(t *testing.T) {
+		input, err := migrate.ReadSeedFile("../fixtures/seeds4/20190104001122_links.ndjson")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "link_table_1", input.Seeds[0].Table)
+		assert.Equal(t, "from ndjson", input.Seeds[0].Insert[0]["foo"])
+	})
+
+	t.Run("it reads json seed input", func(t *testing.T) {
+		input, err := migrate.ReadSeedFile("../fixtures/seeds4/20190105001122_seed.json")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "hoge", input.Vars["foobar"])
+		assert.Equal(t, "test_table_1", input.Seeds[0].Table)
+	})
+
+	t.Run("it reads csv files with a byte order mark and nulls", func(t *testing.T) {
+		input, err := migrate.ReadSeedFile("../fixtures/seeds13/20190101001122_excel.csv")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, []migrate.SeedRow{
+			{"name": "excel", "foo": nil},
+			{"name": "empty", "foo": ""},
+		}, input.Seeds[0].Insert, "should strip the byte order mark and read \\N as null")
+	})
+
+	t.Run("it refuses ndjson lines that are not objects", func(t *testing.T) {
+		_, err := migrate.ReadSeedFile("../fixtures/seeds13/20190102001122_null.ndjson")
+		assert.NotNil(t, err, "should return an error")
+		assert.Contains(t, err.Error(), "line 2: must be a json object", "should say which line")
+	})
+
+	t.Run("it returns an error for unknown formats", func(t *testing.T) {
+		_, err := migrate.ReadSeedFile("../fixtures/ssh/about.txt")
+		assert.NotNil(t, err, "should return an error")
+	})
+}
+
+func TestApplySeedsFormats(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	seeds := []migrate.SeedFile{
+		{Path: "../fixtures/seeds4/20190101001122_test_table_1.csv"},
+		{Path: "../fixtures/seeds4/20190102001122_links.csv"},
+	}
+
+	t.Run("it seeds csv files", func(t *testing.T) {
+		migrate.ApplySeeds(db, seeds)
+		assert.Equal(t, int64(2), getRowCount("test_table_1"), "should seed every row")
+		assert.Equal(t, int64(1), getRowCount("link_table_1"), "should seed every row")
+	})
+}
+
+func TestReadTypedSeedValues(t *testing.T) {
+	t.Run("it keeps yaml types and tags", func(t *testing.T) {
+		input, err := migrate.ReadSeedFile("../fixtures/seeds5/20190101001122_typed.yaml")
+		assert.Nil(t, err, "should not return an error")
+
+		row := input.Seeds[0].Insert[0]
+		assert.Nil(t, row["name"], "should read !null as nil")
+		assert.Equal(t, 12, row["amount"], "should keep integers")
+		assert.Equal(t, 1.5, row["price"], "should keep floats")
+		assert.Equal(t, true, row["active"], "should keep booleans")
+		assert.Equal(t, migrate.JSONValue(`{"tags":["a","b"]}`), row["doc"], "should encode structured json")
+		assert.Equal(t, migrate.JSONValue(`{"name": "{{ var "name" }}"}`), row["raw_doc"], "should keep json strings")
+		assert.Equal(t, migrate.Base64Value("aGVsbG8="), row["data"], "should keep base64")
+		assert.Equal(t, migrate.SQLValue("NOW()"), row["created_at"], "should keep raw sql")
+	})
+}
+
+func TestApplyTypedSeeds(t *testing.T) {
+	mustExec(`
+		CREATE TABLE typed_table (
+			id INT AUTO_INCREMENT,
+			name VARCHAR(32) NULL,
+			amount INT,
+			price DECIMAL(5, 2),
+			active BOOLEAN,
+			doc JSON,
+			meta JSON,
+			raw_doc JSON,
+			data BLOB,
+			created_at DATETIME,
+			PRIMARY KEY (id)
+		);
+	`)
+
+	defer mustExec("DROP TABLE IF EXISTS typed_table")
+
+	t.Run("it inserts typed values", func(t *testing.T) {
+		migrate.ApplySeeds(db, []migrate.SeedFile{{Path: "../fixtures/seeds5/20190101001122_typed.yaml"}})
+
+		assert.Equal(t, int64(1), getRowCount("typed_table WHERE name IS NULL"), "should insert null")
+		assert.Equal(t, int64(1), getRowCount("typed_table WHERE amount = 12 AND active = 1"), "should insert numbers and booleans")
+		assert.Equal(t, int64(1), getRowCount(`typed_table WHERE raw_doc->>"$.name" = "hoge"`), "should run json templates")
+		assert.Equal(t, int64(1), getRowCount(`typed_table WHERE meta->>"$.owner" = "hoge" AND meta->>"$.labels[0]" = "hoge-0"`), "should run templates in structured json")
+		assert.Equal(t, int64(1), getRowCount(`typed_table WHERE data = "hello"`), "should decode base64")
+		assert.Equal(t, int64(1), getRowCount("typed_table WHERE created_at IS NOT NULL"), "should insert raw sql")
+	})
+}
+
+func TestExpandSeedIncludes(t *testing.T) {
+	t.Run("it places included files before the files that include them", func(t *testing.T) {
+		files, err := migrate.ExpandSeedIncludes([]migrate.SeedFile{
+			{Path: "../fixtures/seeds9/20190102001122_users.yaml"},
+			{Path: "../fixtures/seeds9/20190101001122_base.yaml"},
+		})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, []migrate.SeedFile{
+			{Path: "../fixtures/seeds9/20190101001122_base.yaml"},
+			{Path: "../fixtures/seeds9/20190102001122_users.yaml"},
+		}, files, "should include each file once")
+	})
+
+	t.Run("it fails on files that include each other", func(t *testing.T) {
+		_, err := migrate.ExpandSeedIncludes([]migrate.SeedFile{{Path: "../fixtures/seeds9/cycle/a.yaml"}})
+
+		assert.NotNil(t, err, "should return an error")
+		assert.Contains(t, err.Error(), "include each other", "should explain the error")
+	})
+}
+
+func TestSeedTables(t *testing.T) {
+	t.Run("it lists the tables that seed files write to", func(t *testing.T) {
+		tables, err := migrate.SeedTables([]migrate.SeedFile{
+			{Path: "../fixtures/seeds0/20190101001122_seed_1.yaml"},
+			{Path: "../fixtures/seeds9/20190102001122_users.yaml"},
+		})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, []string{"test_table_1", "link_table_1"}, tables, "should list each table once")
+	})
+}
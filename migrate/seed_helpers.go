@@ -0,0 +1,245 @@
+package migrate
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	fakeFirstNames = []string{
+		"Aiko", "Ben", "Chloe", "Daichi", "Emma", "Felix", "Greta", "Haruto", "Ines", "Jonas",
+		"Kenji", "Lena", "Mia", "Noah", "Olivia", "Paul", "Rin", "Sophie", "Taro", "Yuki",
+	}
+
+	fakeLastNames = []string{
+		"Bauer", "Fischer", "Hoffmann", "Ito", "Kato", "Klein", "Kobayashi", "Meyer", "Muller", "Nakamura",
+		"Sato", "Schmidt", "Schneider", "Suzuki", "Takahashi", "Tanaka", "Wagner", "Watanabe", "Weber", "Yamamoto",
+	}
+)
+
+// the layout used for dates in seed files
+const seedDateLayout = "2006-01-02 15:04:05"
+
+// get the number of times a seed row should be inserted
+func rowCount(insert SeedRow) (int, error) {
+	val, ok := insert[CountKey]
+
+	if !ok {
+		return 1, nil
+	}
+
+	count := stringValue(val)
+	n, err := strconv.Atoi(count)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be zero or more, got: %s", CountKey, count)
+	}
+
+	return n, nil
+}
+
+// get the reference name of a copy of a seed row, if it has one. Every copy of a row with a count gets its own
+// name, made of the reference and the index of the copy, like admin.0.
+func rowRef(insert SeedRow, index int) (string, bool) {
+	if _, ok := insert[RefKey]; !ok {
+		return "", false
+	}
+
+	ref := stringValue(insert[RefKey])
+
+	if _, counted := insert[CountKey]; counted {
+		ref = fmt.Sprintf("%s.%d", ref, index)
+	}
+
+	return ref, true
+}
+
+// fakeFuncs returns template helpers that generate fake data. All values come from the passed random source,
+// so the same seed always generates the same data.
+func fakeFuncs(rng *rand.Rand) template.FuncMap {
+	sequences := make(map[string]int)
+	emails := 0
+
+	return template.FuncMap{
+		"fakeName": func() string {
+			return fakeFirstNames[rng.Intn(len(fakeFirstNames))] + " " + fakeLastNames[rng.Intn(len(fakeLastNames))]
+		},
+		"fakeEmail": func() string {
+			emails++
+			first := fakeFirstNames[rng.Intn(len(fakeFirstNames))]
+			last := fakeLastNames[rng.Intn(len(fakeLastNames))]
+			return strings.ToLower(fmt.Sprintf("%s.%s%d@example.com", first, last, emails))
+		},
+		"fakeUUID": func() string {
+			b := make([]byte, 16)
+			rng.Read(b)
+			b[6] = (b[6] & 0x0f) | 0x40 // version 4
+			b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		},
+		"fakeDate": func(from, to string) (string, error) {
+			start, err := parseSeedDate(from)
+
+			if err != nil {
+				return "", err
+			}
+
+			end, err := parseSeedDate(to)
+
+			if err != nil {
+				return "", err
+			}
+
+			if !end.After(start) {
+				return "", fmt.Errorf("fakeDate needs a range, got: %s to %s", from, to)
+			}
+
+			offset := time.Duration(rng.Int63n(int64(end.Sub(start))))
+			return start.Add(offset).Format(seedDateLayout), nil
+		},
+		"randInt": func(min, max int) (int, error) {
+			if max < min {
+				return 0, fmt.Errorf("randInt needs min <= max, got: %d, %d", min, max)
+			}
+			return min + rng.Intn(max-min+1), nil
+		},
+		"pick": func(items ...string) (string, error) {
+			if len(items) == 0 {
+				return "", fmt.Errorf("pick needs at least one item")
+			}
+			return items[rng.Intn(len(items))], nil
+		},
+		"sequence": func(name string) int {
+			sequences[name]++
+			return sequences[name]
+		},
+	}
+}
+
+// utilFuncs returns template helpers for the environment, files, secrets, dates and hashes. Files are read
+// relative to dir, the directory of the seed file, and secret uris are resolved with secret.
+func utilFuncs(dir string, secret func(uri string) (string, error)) template.FuncMap {
+	return template.FuncMap{
+		"env": func(name string, fallback ...string) (string, error) {
+			if val, ok := os.LookupEnv(name); ok {
+				return val, nil
+			}
+			if len(fallback) > 0 {
+				return fallback[0], nil
+			}
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		},
+		"file": func(path string) (string, error) {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			contents, err := ioutil.ReadFile(path)
+			return string(contents), err
+		},
+		"secret": func(uri string) (string, error) {
+			if secret == nil {
+				return "", fmt.Errorf("secrets are not available, cannot resolve %s", uri)
+			}
+			return secret(uri)
+		},
+		"now": func() string {
+			return time.Now().Format(seedDateLayout)
+		},
+		"dateAdd": func(duration, date string) (string, error) {
+			t, err := parseSeedDate(date)
+
+			if err != nil {
+				return "", err
+			}
+
+			d, err := parseSeedDuration(duration)
+			return t.Add(d).Format(seedDateLayout), err
+		},
+		"uuid": func() (string, error) {
+			b := make([]byte, 16)
+
+			if _, err := cryptorand.Read(b); err != nil {
+				return "", err
+			}
+
+			b[6] = (b[6] & 0x0f) | 0x40 // version 4
+			b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+		},
+		"sha256": func(source string) string {
+			sum := sha256.Sum256([]byte(source))
+			return hex.EncodeToString(sum[:])
+		},
+		"hmac": func(key, message string) string {
+			mac := hmac.New(sha256.New, []byte(key))
+			mac.Write([]byte(message))
+			return hex.EncodeToString(mac.Sum(nil))
+		},
+		"argon2": func(source string) (string, error) {
+			return argon2Hash(source)
+		},
+	}
+}
+
+// the argon2id parameters used by the argon2 helper
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+)
+
+// hash a password with argon2id and a random salt, encoded in the usual $argon2id$ format
+func argon2Hash(source string) (string, error) {
+	salt := make([]byte, 16)
+
+	if _, err := cryptorand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(source), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// parse a duration, which may also be a number of days like 30d or -7d
+func parseSeedDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// parse a date in either date or date time format
+func parseSeedDate(value string) (time.Time, error) {
+	if t, err := time.Parse(seedDateLayout, value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", value)
+}
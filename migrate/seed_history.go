@@ -0,0 +1,97 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// SeedHistoryTable records which seed files were applied, and the checksum of each file when it was applied.
+const SeedHistoryTable = "seed_history"
+
+// InitSeedHistoryTable creates the seed history table, if it does not exist. It has to be created outside of
+// the seed transaction, since mysql commits open transactions when a table is created.
+func InitSeedHistoryTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ` + SeedHistoryTable + `(
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (name)
+		);
+	`)
+
+	return err
+}
+
+// SeedChecksum returns the sha256 checksum of a seed file.
+func SeedChecksum(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// read the checksum of every applied seed file by name
+func appliedSeeds(db execer) (map[string]string, error) {
+	rows, err := db.Query("SELECT name, checksum FROM " + SeedHistoryTable)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := make(map[string]string)
+
+	for rows.Next() {
+		var name, checksum string
+
+		if err := rows.Scan(&name, &checksum); err != nil {
+			return nil, err
+		}
+
+		applied[name] = checksum
+	}
+
+	return applied, rows.Err()
+}
+
+// record that a seed file was applied with the given checksum
+func recordSeed(db execer, name, checksum string) error {
+	if _, err := db.Exec("DELETE FROM "+SeedHistoryTable+" WHERE name = ?", name); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("INSERT INTO "+SeedHistoryTable+" (name, checksum) VALUES (?, ?)", name, checksum)
+	return err
+}
+
+// the name a seed file is known by while its includes are expanded
+func seedName(path string) string {
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+// the name a seed file is recorded under in the seed history. It is the path relative to the root, so it stays
+// the same whichever directory migrant runs from, or the file name if there is no root or the file is outside it.
+func historyName(root, path string) string {
+	if root != "" {
+		absRoot, rootErr := filepath.Abs(root)
+		absPath, pathErr := filepath.Abs(path)
+
+		if rootErr == nil && pathErr == nil {
+			if rel, err := filepath.Rel(absRoot, absPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return filepath.ToSlash(rel)
+			}
+		}
+	}
+
+	return filepath.Base(path)
+}
@@ -0,0 +1,172 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// ExpandSeedIncludes returns the seed files with the files they include placed before them. Included paths are
+// relative to the including file. Every file is only returned once, so a file that is included by several
+// seed files is applied once, before the first of them.
+func ExpandSeedIncludes(seedFiles []SeedFile) ([]SeedFile, error) {
+	expanded := make([]SeedFile, 0)
+	found := make(map[string]bool)
+
+	for s := range seedFiles {
+		if err := expandSeedFile(seedFiles[s].Path, nil, found, &expanded); err != nil {
+			return nil, err
+		}
+	}
+
+	return expanded, nil
+}
+
+// add a seed file after the files it includes. stack holds the files that are being included, to catch cycles.
+func expandSeedFile(path string, stack []string, found map[string]bool, expanded *[]SeedFile) error {
+	name := seedName(path)
+
+	for s := range stack {
+		if stack[s] == name {
+			return fmt.Errorf("seed files include each other: %s", strings.Join(append(stack[s:], name), " -> "))
+		}
+	}
+
+	if found[name] {
+		return nil
+	}
+
+	input, err := ReadSeedFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range input.Include {
+		err := expandSeedFile(relativeSeedPath(path, input.Include[i]), append(stack, name), found, expanded)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	found[name] = true
+	*expanded = append(*expanded, SeedFile{Path: path})
+
+	return nil
+}
+
+// resolve a path relative to the directory of a seed file
+func relativeSeedPath(seedPath, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(seedPath), path)
+}
+
+// collect the variables of a seed file. Variables from vars files come first, in order, then the variables of
+// the file itself, then the overrides.
+func loadSeedVars(path string, input SeedInput, overrides map[string]string) (map[string]string, error) {
+	vars := make(map[string]string)
+
+	for f := range input.VarsFiles {
+		varsPath := relativeSeedPath(path, input.VarsFiles[f])
+		contents, err := ioutil.ReadFile(varsPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// yaml is a superset of json, so this reads both
+		fileVars := make(map[string]interface{})
+
+		if err := yaml.Unmarshal(contents, &fileVars); err != nil {
+			return nil, fmt.Errorf("%s: %s", varsPath, err)
+		}
+
+		for name, val := range fileVars {
+			switch val.(type) {
+			case map[string]interface{}, []interface{}:
+				return nil, fmt.Errorf("%s: variable %s must be a single value", varsPath, name)
+			}
+
+			vars[name] = stringValue(val)
+		}
+	}
+
+	for name, val := range input.Vars {
+		vars[name] = val
+	}
+
+	for name, val := range overrides {
+		vars[name] = val
+	}
+
+	return vars, nil
+}
+
+// varFunc returns the var helper. Variables are templates themselves, which are executed with the passed
+// helpers the first time they are used. Unknown variables are empty.
+func varFunc(vars map[string]string, funcs template.FuncMap) func(name string) (string, error) {
+	resolved := make(map[string]string)
+	resolving := make(map[string]bool)
+
+	var resolve func(name string) (string, error)
+
+	resolve = func(name string) (string, error) {
+		if val, ok := resolved[name]; ok {
+			return val, nil
+		}
+
+		if resolving[name] {
+			return "", fmt.Errorf("variable %s refers to itself", name)
+		}
+
+		resolving[name] = true
+		defer delete(resolving, name)
+
+		val, err := executeSeedTemplate("var "+name, vars[name], funcs, RowData{})
+
+		if err != nil {
+			return "", err
+		}
+
+		resolved[name] = val
+		return val, nil
+	}
+
+	return resolve
+}
+
+// SeedTables returns the tables that seed files write to, including the files they include, in the order they
+// are first written to.
+func SeedTables(seedFiles []SeedFile) ([]string, error) {
+	expanded, err := ExpandSeedIncludes(seedFiles)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0)
+
+	for s := range expanded {
+		input, err := ReadSeedFile(expanded[s].Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range input.Seeds {
+			if !contains(tables, input.Seeds[i].Table) {
+				tables = append(tables, input.Seeds[i].Table)
+			}
+		}
+	}
+
+	return tables, nil
+}
@@ -0,0 +1,165 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// SeedRow maps the columns of a seed row to their values. Strings are templates, other yaml and json types are
+// inserted as they are, and tagged values are inserted as described by their tag.
+type SeedRow map[string]interface{}
+
+// SQLValue is raw sql that is inserted as it is, tagged with !sql in yaml.
+type SQLValue string
+
+// JSONValue is a json document, tagged with !json in yaml. It is a template, like strings.
+type JSONValue string
+
+// Base64Value is base64 encoded binary data, tagged with !base64 in yaml.
+type Base64Value string
+
+// UnmarshalYAML reads a seed row, keeping yaml types and the migrant specific tags.
+func (r *SeedRow) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: seed row must be a map", node.Line)
+	}
+
+	row := make(SeedRow)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		val, err := yamlSeedValue(node.Content[i+1])
+
+		if err != nil {
+			return fmt.Errorf("line %d: column %s: %s", node.Content[i+1].Line, node.Content[i].Value, err)
+		}
+
+		row[node.Content[i].Value] = val
+	}
+
+	*r = row
+	return nil
+}
+
+// convert a yaml node into a seed value
+func yamlSeedValue(node *yaml.Node) (interface{}, error) {
+	switch node.Tag {
+	case "!null", "!!null":
+		return nil, nil
+
+	case "!sql":
+		return SQLValue(node.Value), nil
+
+	case "!base64":
+		return Base64Value(node.Value), nil
+
+	case "!json":
+		if node.Kind == yaml.ScalarNode {
+			return JSONValue(node.Value), nil
+		}
+
+		// structured yaml is converted into a json document
+		var doc interface{}
+
+		if err := node.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		encoded, err := json.Marshal(doc)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return JSONValue(encoded), nil
+
+	case "!!str":
+		return node.Value, nil
+
+	case "!!binary":
+		return base64.StdEncoding.DecodeString(node.Value)
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("maps and lists must be tagged with !json")
+	}
+
+	var val interface{}
+	err := node.Decode(&val)
+
+	return val, err
+}
+
+// UnmarshalJSON reads a seed row, keeping json types. Objects and arrays are inserted as json documents.
+func (r *SeedRow) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+
+	row, err := jsonSeedRow(raw)
+
+	if err != nil {
+		return err
+	}
+
+	*r = row
+	return nil
+}
+
+// convert decoded json into a seed row
+func jsonSeedRow(raw map[string]interface{}) (SeedRow, error) {
+	row := make(SeedRow)
+
+	for col, val := range raw {
+		switch v := val.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				row[col] = i
+			} else {
+				f, err := v.Float64()
+
+				if err != nil {
+					return nil, err
+				}
+
+				row[col] = f
+			}
+
+		case map[string]interface{}, []interface{}:
+			encoded, err := json.Marshal(v)
+
+			if err != nil {
+				return nil, err
+			}
+
+			row[col] = JSONValue(encoded)
+
+		default:
+			row[col] = v
+		}
+	}
+
+	return row, nil
+}
+
+// stringValue returns the value as a string, for directives like _ref and _count.
+func stringValue(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case int:
+		return strconv.Itoa(v)
+	}
+
+	return fmt.Sprint(val)
+}
@@ -4,7 +4,8 @@ import "database/sql"
 
 // DatabaseInfo returns information about a database.
 type DatabaseInfo struct {
-	hasMigrationTable bool
+	hasMigrationTable   bool
+	migrationNameLength int
 }
 
 // HasMigrationTable returns true if the database has a migration table.
@@ -12,14 +13,30 @@ func (d *DatabaseInfo) HasMigrationTable() bool {
 	return d.hasMigrationTable
 }
 
+// MigrationNameLength returns the width of the name column of the migration table.
+func (d *DatabaseInfo) MigrationNameLength() int {
+	return d.migrationNameLength
+}
+
 // Stat returns information about the provided database.
 func Stat(db *sql.DB) (*DatabaseInfo, error) {
 	i := DatabaseInfo{}
 
 	_, err := db.Exec("SELECT count(*) FROM migrations")
 
-	if err == nil {
-		i.hasMigrationTable = true
+	if err != nil {
+		return &i, nil
+	}
+
+	i.hasMigrationTable = true
+
+	err = db.QueryRow(`
+		SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'migrations' AND COLUMN_NAME = 'name'
+	`).Scan(&i.migrationNameLength)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return &i, nil
@@ -0,0 +1,73 @@
+package migrate
+
+import (
+	"database/sql"
+	"path"
+)
+
+// the tables migrant keeps its own records in, which are never truncated or cleared
+var bookkeepingTables = []string{"migrations", CheckpointTable, SeedHistoryTable}
+
+// TableFilter picks tables by glob patterns, like `*_lookup`. The tables migrant keeps its own records in, like
+// the migration table and the seed history, never match.
+type TableFilter struct {
+	Include []string // if set, only tables that match one of these patterns match
+	Exclude []string // tables that match one of these patterns never match
+}
+
+// Match returns true if the filter picks the table.
+func (f TableFilter) Match(table string) bool {
+	if contains(bookkeepingTables, table) || matchesAny(f.Exclude, table) {
+		return false
+	}
+
+	return len(f.Include) == 0 || matchesAny(f.Include, table)
+}
+
+// Validate returns an error if any of the patterns is malformed.
+func (f TableFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListTables returns the names of all tables in a mysql database. Views are left out, since they hold no rows of
+// their own.
+func ListTables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SHOW FULL TABLES WHERE Table_type = 'BASE TABLE'")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tables := make([]string, 0)
+
+	for rows.Next() {
+		var t, kind string
+
+		if err := rows.Scan(&t, &kind); err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, t)
+	}
+
+	return tables, rows.Err()
+}
+
+// returns true if the name matches one of the patterns
+func matchesAny(patterns []string, name string) bool {
+	for p := range patterns {
+		if ok, _ := path.Match(patterns[p], name); ok {
+			return true
+		}
+	}
+
+	return false
+}
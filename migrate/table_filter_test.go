@@ -0,0 +1,41 @@
+package migrate_test
+
+import (
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableFilter(t *testing.T) {
+	t.Run("it matches every table but migrations by default", func(t *testing.T) {
+		filter := migrate.TableFilter{}
+
+		assert.True(t, filter.Match("users"), "should match tables")
+		assert.False(t, filter.Match("migrations"), "should never match the migration table")
+		assert.False(t, filter.Match(migrate.SeedHistoryTable), "should never match the seed history")
+		assert.False(t, filter.Match(migrate.CheckpointTable), "should never match data migration checkpoints")
+		assert.False(t, migrate.TableFilter{Include: []string{"*"}}.Match(migrate.SeedHistoryTable), "should not include bookkeeping tables")
+	})
+
+	t.Run("it keeps excluded tables", func(t *testing.T) {
+		filter := migrate.TableFilter{Exclude: []string{"countries", "*_lookup"}}
+
+		assert.True(t, filter.Match("orders"), "should match other tables")
+		assert.False(t, filter.Match("countries"), "should not match excluded tables")
+		assert.False(t, filter.Match("currency_lookup"), "should not match excluded patterns")
+	})
+
+	t.Run("it only matches included tables", func(t *testing.T) {
+		filter := migrate.TableFilter{Include: []string{"order*"}, Exclude: []string{"order_statuses"}}
+
+		assert.True(t, filter.Match("orders"), "should match included tables")
+		assert.False(t, filter.Match("users"), "should not match other tables")
+		assert.False(t, filter.Match("order_statuses"), "should prefer exclusions")
+	})
+
+	t.Run("it rejects malformed patterns", func(t *testing.T) {
+		assert.NotNil(t, migrate.TableFilter{Exclude: []string{"[a-"}}.Validate(), "should return an error")
+		assert.Nil(t, migrate.TableFilter{Exclude: []string{"*_lookup"}}.Validate(), "should not return an error")
+	})
+}
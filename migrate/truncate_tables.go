@@ -1,6 +1,7 @@
 package migrate
 
 import (
+	"context"
 	"database/sql"
 	"log"
 )
@@ -8,41 +9,46 @@ import (
 // TruncateTables momentarily disables foreign key checks, then truncates all
 // tables in the database. It will not delete entries from the migration table.
 func TruncateTables(db *sql.DB) error {
-	rows, err := db.Query("SHOW TABLES")
-
-	if err != nil {
+	if err := TruncateTablesWithFilter(db, TableFilter{}); err != nil {
 		log.Fatal(err)
 	}
 
-	defer rows.Close()
+	return nil
+}
 
-	var tables []string
+// TruncateTablesWithFilter truncates the tables that the filter picks, with foreign key checks disabled while it
+// does. The migration table is never truncated.
+func TruncateTablesWithFilter(db *sql.DB, filter TableFilter) error {
+	tables, err := ListTables(db)
 
-	for rows.Next() {
-		var t string
-		err := rows.Scan(&t)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			log.Fatal(err)
-		}
-		tables = append(tables, t)
+	// foreign key checks are set per connection, so everything has to run on the same one
+	conn, err := db.Conn(context.Background())
+
+	if err != nil {
+		return err
 	}
-	rows.Close()
 
-	db.Exec("SET FOREIGN_KEY_CHECKS = 0;")
-	defer db.Exec("SET FORGEIGN KEY CHECKS = 1;")
+	defer conn.Close()
+
+	statements := []string{"SET FOREIGN_KEY_CHECKS = 0"}
 
 	for t := range tables {
-		if tables[t] == "migrations" {
-			continue
+		if filter.Match(tables[t]) {
+			statements = append(statements, "TRUNCATE `"+tables[t]+"`")
 		}
+	}
 
-		_, err := db.Exec("TRUNCATE " + tables[t])
-
-		if err != nil {
-			log.Fatal(err)
+	for s := range statements {
+		if _, err := conn.ExecContext(context.Background(), statements[s]); err != nil {
+			conn.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
+			return err
 		}
 	}
 
-	return nil
+	_, err = conn.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
+	return err
 }
@@ -32,3 +32,19 @@ func TestTruncateTables(t *testing.T) {
 		assert.Equal(t, int64(2), count, "it should not delete migrations")
 	})
 }
+
+func TestTruncateTablesWithFilter(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	mustExec(`INSERT INTO test_table_1 (name) VALUES ("foo")`)
+	mustExec(`INSERT INTO link_table_1 (test_table_id, foo) VALUES (LAST_INSERT_ID(), "bar")`)
+
+	t.Run("it keeps excluded tables", func(t *testing.T) {
+		err := migrate.TruncateTablesWithFilter(db, migrate.TableFilter{Exclude: []string{"test_*"}})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, int64(1), getRowCount("test_table_1"), "should keep excluded tables")
+		assert.Equal(t, int64(0), getRowCount("link_table_1"), "should truncate other tables")
+	})
+}
@@ -0,0 +1,359 @@
+package migrate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SeedProblem describes something in a seed file that would make applying it fail.
+type SeedProblem struct {
+	Path    string
+	Seed    int // the index of the seed in the file, -1 for problems with the whole file
+	Row     int // the index of the row in the seed, -1 for problems with the whole seed
+	Column  string
+	Message string
+}
+
+func (p SeedProblem) String() string {
+	location := p.Path
+
+	if p.Seed >= 0 {
+		location += fmt.Sprintf(", seed %d", p.Seed)
+	}
+
+	if p.Row >= 0 {
+		location += fmt.Sprintf(", row %d", p.Row)
+	}
+
+	if p.Column != "" {
+		location += ", column " + p.Column
+	}
+
+	if location == "" {
+		return p.Message
+	}
+
+	return location + ": " + p.Message
+}
+
+// a column as described by information_schema
+type seedColumn struct {
+	DataType   string
+	Nullable   bool
+	HasDefault bool
+}
+
+// ValidateSeeds checks seed files without changing anything. Every file is parsed, and every template is
+// executed with the same helpers as when seeding, except that ids and refs are counted instead of read from
+// the database, and hashes and secrets are not worked out. References to rows and variables that do not exist
+// are problems.
+//
+// If a database is passed, the tables and columns of every seed are also checked to exist, values are checked
+// against column types, and columns that cannot be null and have no default are checked to be set.
+func ValidateSeeds(db *sql.DB, seedFiles []SeedFile, options SeedOptions) ([]SeedProblem, error) {
+	problems := make([]SeedProblem, 0)
+	fileProblem := func(path string, err error) {
+		problems = append(problems, SeedProblem{Path: path, Seed: -1, Row: -1, Message: err.Error()})
+	}
+
+	expanded, err := ExpandSeedIncludes(seedFiles)
+
+	if err != nil {
+		fileProblem("", err)
+		return problems, nil
+	}
+
+	schemas := make(map[string]map[string]seedColumn)
+	declaredRefs := make(map[string]map[string]bool)
+
+	for s := range expanded {
+		path := expanded[s].Path
+		input, err := ReadSeedFile(path)
+
+		if err != nil {
+			fileProblem(path, err)
+			continue
+		}
+
+		vars, err := loadSeedVars(path, input, options.Vars)
+
+		if err != nil {
+			fileProblem(path, err)
+			continue
+		}
+
+		idCounts := make(map[string]int)
+		f := validationFuncs(path, input, options, idCounts, declaredRefs, vars)
+
+		for set := range input.Seeds {
+			seed := input.Seeds[set]
+			problem := func(row int, column string, message string) {
+				problems = append(problems, SeedProblem{Path: path, Seed: set, Row: row, Column: column, Message: message})
+			}
+
+			var schema map[string]seedColumn
+
+			if db != nil {
+				if schema, err = tableSchema(db, options.Driver, schemas, seed.Table); err != nil {
+					return nil, err
+				}
+
+				if len(schema) == 0 {
+					problem(-1, "", fmt.Sprintf("table %s does not exist", seed.Table))
+				} else if options.Upsert && len(seed.Keys) > 0 {
+					if err := checkUniqueKeys(db, seed.Table, seed.Keys); err != nil {
+						problem(-1, "", err.Error())
+					}
+				}
+			}
+
+			for i := range seed.Insert {
+				insert := seed.Insert[i]
+				count, err := rowCount(insert)
+
+				if err != nil {
+					problem(i, CountKey, err.Error())
+					continue
+				}
+
+				for n := 0; n < count; n++ {
+					ref, hasRef := rowRef(insert, n)
+
+					if !hasRef {
+						break
+					}
+
+					if declaredRefs[seed.Table][ref] {
+						problem(i, RefKey, fmt.Sprintf("reference %q is already defined for table %s", ref, seed.Table))
+						break
+					}
+
+					if _, ok := declaredRefs[seed.Table]; !ok {
+						declaredRefs[seed.Table] = make(map[string]bool)
+					}
+
+					declaredRefs[seed.Table][ref] = true
+				}
+
+				cols := make([]string, 0)
+
+				for col := range insert {
+					if col != RefKey && col != CountKey {
+						cols = append(cols, col)
+					}
+				}
+
+				sort.Strings(cols)
+
+				if options.Upsert && len(seed.Keys) > 0 {
+					if _, err := keyValues(seed.Keys, cols, make([]interface{}, len(cols))); err != nil {
+						problem(i, "", err.Error())
+					}
+				}
+
+				if len(schema) > 0 {
+					for _, msg := range missingColumns(schema, insert) {
+						problem(i, "", msg)
+					}
+				}
+
+				// only report the first problem of each column for repeated rows
+				failed := make(map[string]bool)
+
+				for n := 0; n < count; n++ {
+					for c := range cols {
+						if failed[cols[c]] {
+							continue
+						}
+
+						val, err := resolveSeedValue(cols[c], insert[cols[c]], f, RowData{Index: n})
+
+						if err == nil && len(schema) > 0 {
+							err = checkSeedColumn(schema, cols[c], val)
+						}
+
+						if err != nil {
+							failed[cols[c]] = true
+							problem(i, cols[c], err.Error())
+						}
+					}
+
+					idCounts[seed.Table]++
+				}
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// the helpers used to validate a seed file. id and ref check rows that were seen so far instead of reading
+// ids, var fails on unknown variables, and hashes and secrets return placeholders.
+func validationFuncs(path string, input SeedInput, options SeedOptions, idCounts map[string]int, declaredRefs map[string]map[string]bool, vars map[string]string) template.FuncMap {
+	randomSeed := options.RandomSeed
+
+	if input.RandomSeed != nil {
+		randomSeed = *input.RandomSeed
+	}
+
+	f := fakeFuncs(rand.New(rand.NewSource(randomSeed)))
+
+	for name, fn := range utilFuncs(filepath.Dir(path), nil) {
+		f[name] = fn
+	}
+
+	f["id"] = func(source string, index int) (string, error) {
+		if index < 0 || index >= idCounts[source] {
+			return "", fmt.Errorf("no id at index %d for table %s (%d rows before it)", index, source, idCounts[source])
+		}
+		return strconv.Itoa(index + 1), nil
+	}
+	f["ref"] = func(source, name string) (string, error) {
+		if !declaredRefs[source][name] {
+			return "", fmt.Errorf("undefined reference %q for table %s", name, source)
+		}
+		return "1", nil
+	}
+	f["secret"] = func(uri string) string {
+		return uri
+	}
+	f["bcrypt"] = func(source string) string {
+		return strings.Repeat("x", 60)
+	}
+	f["argon2"] = func(source string) string {
+		return "$argon2id$"
+	}
+
+	resolve := varFunc(vars, f)
+	f["var"] = func(name string) (string, error) {
+		if _, ok := vars[name]; !ok {
+			return "", fmt.Errorf("undefined variable %s", name)
+		}
+		return resolve(name)
+	}
+
+	return f
+}
+
+// read the columns of a table, caching them for later seeds. An empty schema means the table does not exist.
+func tableSchema(db *sql.DB, driver string, cache map[string]map[string]seedColumn, table string) (map[string]seedColumn, error) {
+	if schema, ok := cache[table]; ok {
+		return schema, nil
+	}
+
+	var q string
+
+	switch driver {
+	case "mysql":
+		q = `
+			SELECT COLUMN_NAME, DATA_TYPE, IS_NULLABLE = 'YES',
+				COLUMN_DEFAULT IS NOT NULL OR EXTRA LIKE '%auto_increment%' OR EXTRA LIKE '%GENERATED%'
+			FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		`
+	default:
+		return nil, fmt.Errorf("cannot check seed tables for driver: %s", driver)
+	}
+
+	rows, err := db.Query(q, table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	schema := make(map[string]seedColumn)
+
+	for rows.Next() {
+		var name string
+		col := seedColumn{}
+
+		if err := rows.Scan(&name, &col.DataType, &col.Nullable, &col.HasDefault); err != nil {
+			return nil, err
+		}
+
+		col.DataType = strings.ToLower(col.DataType)
+		schema[name] = col
+	}
+
+	cache[table] = schema
+	return schema, rows.Err()
+}
+
+// list the columns that a row is missing or has too many of
+func missingColumns(schema map[string]seedColumn, insert SeedRow) []string {
+	problems := make([]string, 0)
+
+	for name, col := range schema {
+		if _, set := insert[name]; !set && !col.Nullable && !col.HasDefault {
+			problems = append(problems, fmt.Sprintf("column %s cannot be null and has no default", name))
+		}
+	}
+
+	for name := range insert {
+		if _, exists := schema[name]; !exists && name != RefKey && name != CountKey {
+			problems = append(problems, fmt.Sprintf("column %s does not exist", name))
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// check that a value fits the type of its column
+func checkSeedColumn(schema map[string]seedColumn, name string, val interface{}) error {
+	col, exists := schema[name]
+
+	if !exists {
+		return nil // reported with the rest of the row
+	}
+
+	if val == nil {
+		if !col.Nullable {
+			return fmt.Errorf("cannot be null")
+		}
+		return nil
+	}
+
+	if _, isSQL := val.(SQLValue); isSQL {
+		return nil
+	}
+
+	text, isText := val.(string)
+
+	switch col.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "decimal", "numeric",
+		"float", "double", "real", "double precision":
+		if isText {
+			if _, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err != nil {
+				return fmt.Errorf("%q is not a number", text)
+			}
+		}
+
+	case "date", "datetime", "timestamp", "timestamp without time zone", "timestamp with time zone":
+		if !isText {
+			return fmt.Errorf("%v is not a date", val)
+		}
+
+		if _, err := parseSeedDate(text); err != nil {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				return fmt.Errorf("%q is not a date", text)
+			}
+		}
+
+	case "json", "jsonb":
+		if isText && !json.Valid([]byte(text)) {
+			return fmt.Errorf("%q is not valid json", text)
+		}
+	}
+
+	return nil
+}
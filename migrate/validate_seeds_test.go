@@ -0,0 +1,84 @@
+package migrate_test
+
+import (
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+// describe each problem as a string
+func seedProblemMessages(problems []migrate.SeedProblem) []string {
+	found := make([]string, 0)
+
+	for p := range problems {
+		found = append(found, problems[p].String())
+	}
+
+	return found
+}
+
+func TestValidateSeeds(t *testing.T) {
+	t.Run("it finds no problems in valid seed files", func(t *testing.T) {
+		problems, err := migrate.ValidateSeeds(nil, []migrate.SeedFile{
+			{Path: "../fixtures/seeds0/20190101001122_seed_1.yaml"},
+			{Path: "../fixtures/seeds9/20190102001122_users.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql"})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Empty(t, problems, "should not find problems")
+	})
+
+	t.Run("it names every copy of a counted row", func(t *testing.T) {
+		problems, err := migrate.ValidateSeeds(nil, []migrate.SeedFile{
+			{Path: "../fixtures/seeds11/20190101001122_counted.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql"})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Empty(t, problems, "should not find problems")
+	})
+
+	t.Run("it runs templates in structured json values", func(t *testing.T) {
+		problems, err := migrate.ValidateSeeds(nil, []migrate.SeedFile{
+			{Path: "../fixtures/seeds5/20190101001122_typed.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql"})
+
+		assert.Nil(t, err, "should not return an error")
+		assert.Empty(t, problems, "should not find problems")
+	})
+
+	t.Run("it finds broken templates and references without a database", func(t *testing.T) {
+		problems, err := migrate.ValidateSeeds(nil, []migrate.SeedFile{
+			{Path: "../fixtures/seeds10/20190101001122_invalid.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql"})
+
+		assert.Nil(t, err, "should not return an error")
+
+		found := seedProblemMessages(problems)
+		assert.Len(t, found, 4, "should find every problem once")
+		assert.Contains(t, found[0], "seed 0, row 0, column name: template", "should find template syntax errors")
+		assert.Contains(t, found[1], "seed 0, row 1, column name", "should find unknown variables")
+		assert.Contains(t, found[1], "undefined variable name", "should find unknown variables in variables")
+		assert.Contains(t, found[2], "seed 0, row 2, column name", "should find ids that do not exist yet")
+		assert.Contains(t, found[2], "no id at index 5", "should count the ids of earlier rows")
+		assert.Contains(t, found[3], `seed 1, row 0, column foo: `, "should find unknown references")
+	})
+}
+
+func TestValidateSeedsSchema(t *testing.T) {
+	dropTestTables := mustHaveTestTables()
+	defer dropTestTables()
+
+	t.Run("it checks tables, columns and types", func(t *testing.T) {
+		problems, err := migrate.ValidateSeeds(db, []migrate.SeedFile{
+			{Path: "../fixtures/seeds10/20190101001122_invalid.yaml"},
+		}, migrate.SeedOptions{Driver: "mysql"})
+
+		assert.Nil(t, err, "should not return an error")
+
+		found := seedProblemMessages(problems)
+		assert.Contains(t, found, "../fixtures/seeds10/20190101001122_invalid.yaml, seed 1, row 0: column test_table_id cannot be null and has no default", "should find missing columns")
+		assert.Contains(t, found, "../fixtures/seeds10/20190101001122_invalid.yaml, seed 1, row 1: column bar does not exist", "should find unknown columns")
+		assert.Contains(t, found, `../fixtures/seeds10/20190101001122_invalid.yaml, seed 1, row 1, column id: "one" is not a number`, "should check types")
+	})
+}
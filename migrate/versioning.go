@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Versioning schemes that can be used to name migration files.
+const (
+	VersioningTimestamp  = "timestamp"  // local time, eg. 20190101001122_add_x.sql
+	VersioningUTC        = "utc"        // utc time, eg. 20190101001122_add_x.sql
+	VersioningSequential = "sequential" // zero padded integers, eg. 0042_add_x.sql
+	VersioningSemver     = "semver"     // semantic versions, eg. 1.2.0_add_x.sql
+)
+
+// MigrationNameLength is the width of the name column in the migrations table.
+const MigrationNameLength = 64
+
+// width that sequential versions are padded to
+const sequentialWidth = 4
+
+var versionPatterns = map[string]*regexp.Regexp{
+	VersioningTimestamp:  regexp.MustCompile(`^(\d{14})_(.*)\.sql$`),
+	VersioningUTC:        regexp.MustCompile(`^(\d{14})_(.*)\.sql$`),
+	VersioningSequential: regexp.MustCompile(`^(\d+)_(.*)\.sql$`),
+	VersioningSemver:     regexp.MustCompile(`^(v?\d+\.\d+\.\d+)_(.*)\.sql$`),
+}
+
+// ValidVersioning returns true if the passed string names a known versioning scheme.
+func ValidVersioning(versioning string) bool {
+	_, ok := versionPatterns[normalizeVersioning(versioning)]
+	return ok
+}
+
+// NextVersion returns the version for a new migration, given the migrations that already exist. For semantic
+// versions, bump must be one of major, minor or patch.
+func NextVersion(versioning string, existing []MigrationFile, bump string) (string, error) {
+	versioning = normalizeVersioning(versioning)
+
+	switch versioning {
+	case VersioningTimestamp:
+		return time.Now().Format("20060102150405"), nil
+
+	case VersioningUTC:
+		return time.Now().UTC().Format("20060102150405"), nil
+
+	case VersioningSequential:
+		width := sequentialWidth
+		next := int64(1)
+
+		for m := range existing {
+			if len(existing[m].Prefix) > width {
+				width = len(existing[m].Prefix)
+			}
+
+			n, err := strconv.ParseInt(existing[m].Prefix, 10, 64)
+
+			if err != nil {
+				return "", err
+			}
+
+			if n >= next {
+				next = n + 1
+			}
+		}
+
+		return fmt.Sprintf("%0*d", width, next), nil
+
+	case VersioningSemver:
+		latest := [3]int{0, 0, 0}
+		prefix := ""
+
+		for m := range existing {
+			v, err := parseSemver(existing[m].Prefix)
+
+			if err != nil {
+				return "", err
+			}
+
+			if compareSemver(v, latest) > 0 {
+				latest = v
+			}
+
+			if strings.HasPrefix(existing[m].Prefix, "v") {
+				prefix = "v"
+			}
+		}
+
+		switch bump {
+		case "major":
+			latest = [3]int{latest[0] + 1, 0, 0}
+		case "minor", "":
+			latest = [3]int{latest[0], latest[1] + 1, 0}
+		case "patch":
+			latest = [3]int{latest[0], latest[1], latest[2] + 1}
+		default:
+			return "", fmt.Errorf("unknown version bump: %s", bump)
+		}
+
+		return fmt.Sprintf("%s%d.%d.%d", prefix, latest[0], latest[1], latest[2]), nil
+	}
+
+	return "", fmt.Errorf("unknown versioning scheme: %s", versioning)
+}
+
+// an empty versioning scheme means the default timestamp scheme
+func normalizeVersioning(versioning string) string {
+	if versioning == "" {
+		return VersioningTimestamp
+	}
+
+	return versioning
+}
+
+// compare two versions of the given scheme, returning -1, 0 or 1
+func compareVersions(versioning, a, b string) int {
+	switch normalizeVersioning(versioning) {
+	case VersioningSequential:
+		x, errA := strconv.ParseInt(a, 10, 64)
+		y, errB := strconv.ParseInt(b, 10, 64)
+
+		if errA == nil && errB == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+
+			return 0
+		}
+
+	case VersioningSemver:
+		x, errA := parseSemver(a)
+		y, errB := parseSemver(b)
+
+		if errA == nil && errB == nil {
+			return compareSemver(x, y)
+		}
+	}
+
+	return strings.Compare(a, b)
+}
+
+// parse a version like 1.2.3 or v1.2.3
+func parseSemver(version string) ([3]int, error) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+
+	if len(parts) != 3 {
+		return v, fmt.Errorf("not a semantic version: %s", version)
+	}
+
+	for p := range parts {
+		n, err := strconv.Atoi(parts[p])
+
+		if err != nil {
+			return v, fmt.Errorf("not a semantic version: %s", version)
+		}
+
+		v[p] = n
+	}
+
+	return v, nil
+}
+
+// compare two parsed semantic versions, returning -1, 0 or 1
+func compareSemver(a, b [3]int) int {
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+
+	return 0
+}
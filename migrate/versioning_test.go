@@ -0,0 +1,77 @@
+package migrate_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/Fantamstick/migrant/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListMigrationFiles(t *testing.T) {
+	t.Run("it lists sequential migrations in order", func(t *testing.T) {
+		files := migrate.ListMigrationFiles("../fixtures/migrations_sequential", migrate.VersioningSequential)
+		assert.Len(t, files, 3, "should return 3 migrations")
+
+		assertMigration(t, &files[0], "0001", "test 1", false)
+		assertMigration(t, &files[1], "0002", "test 2", false)
+		assertMigration(t, &files[2], "0010", "test 3", false)
+	})
+
+	t.Run("it lists semantic version migrations in order", func(t *testing.T) {
+		files := migrate.ListMigrationFiles("../fixtures/migrations_semver", migrate.VersioningSemver)
+		assert.Len(t, files, 3, "should return 3 migrations")
+
+		assertMigration(t, &files[0], "1.2.0", "test 1", false)
+		assertMigration(t, &files[1], "1.9.0", "test 2", false)
+		assertMigration(t, &files[2], "1.10.0", "test 3", false)
+	})
+
+	t.Run("it ignores files from other schemes", func(t *testing.T) {
+		files := migrate.ListMigrationFiles("../fixtures/migrations_semver", migrate.VersioningTimestamp)
+		assert.Len(t, files, 0, "should not return semantic version migrations")
+	})
+}
+
+func TestNextVersion(t *testing.T) {
+	t.Run("it returns a timestamp", func(t *testing.T) {
+		version, err := migrate.NextVersion(migrate.VersioningUTC, nil, "")
+		assert.Nil(t, err, "should not return an error")
+		assert.Regexp(t, regexp.MustCompile(`^\d{14}$`), version, "should be a timestamp")
+	})
+
+	t.Run("it returns the next sequential version", func(t *testing.T) {
+		files := migrate.ListMigrationFiles("../fixtures/migrations_sequential", migrate.VersioningSequential)
+		version, err := migrate.NextVersion(migrate.VersioningSequential, files, "")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "0011", version, "should be one more than the last migration")
+
+		version, err = migrate.NextVersion(migrate.VersioningSequential, nil, "")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "0001", version, "should start at one")
+	})
+
+	t.Run("it returns the next semantic version", func(t *testing.T) {
+		files := migrate.ListMigrationFiles("../fixtures/migrations_semver", migrate.VersioningSemver)
+
+		version, err := migrate.NextVersion(migrate.VersioningSemver, files, "minor")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "1.11.0", version, "should bump minor version")
+
+		version, err = migrate.NextVersion(migrate.VersioningSemver, files, "major")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "2.0.0", version, "should bump major version")
+
+		version, err = migrate.NextVersion(migrate.VersioningSemver, files, "patch")
+		assert.Nil(t, err, "should not return an error")
+		assert.Equal(t, "1.10.1", version, "should bump patch version")
+
+		_, err = migrate.NextVersion(migrate.VersioningSemver, files, "bogus")
+		assert.NotNil(t, err, "should return an error for unknown bumps")
+	})
+
+	t.Run("it returns an error for unknown schemes", func(t *testing.T) {
+		_, err := migrate.NextVersion("bogus", nil, "")
+		assert.NotNil(t, err, "should return an error")
+	})
+}